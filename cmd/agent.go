@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"metis/internal/agent"
 	"metis/internal/api"
 	"metis/pkg/config"
 	"metis/pkg/provider"
@@ -47,6 +48,11 @@ func startAgent() {
 
 	log.Info("API registered.")
 
+	if viper.GetBool("metis.agent.register") {
+		registrar := agent.NewRegistrar(&cli)
+		go registrar.Run()
+	}
+
 	log.WithFields(log.Fields{
 		"port": viper.GetInt("metis.agent.port"),
 	}).Info("Listening & serving")
@@ -8,13 +8,19 @@ import (
 	"metis/pkg/node"
 	"metis/pkg/orchestrator"
 	"metis/pkg/project"
+	"metis/pkg/routing"
+	"metis/pkg/scheduler"
+	"metis/pkg/secret"
+	"metis/pkg/status"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/Strum355/log"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -39,14 +45,20 @@ func startController() {
 
 	config.PrintSettings()
 
+	err := loadControllerID()
+	if err != nil {
+		panic(err)
+	}
+
 	var orch orchestrator.Orchestrator
 
 	if _, err := os.Stat(viper.GetString("metis.home") + "/state.json"); err != nil {
 		orch = orchestrator.NewOrchestrator()
 		log.Info("No previous state found. Creating new Orchestrator.")
 
+		// Nodes may also register themselves, so the nodes directory is optional
 		node_files, err := ioutil.ReadDir("nodes")
-		if err != nil {
+		if err != nil && !os.IsNotExist(err) {
 			panic(err)
 		}
 
@@ -85,6 +97,15 @@ func startController() {
 			if err != nil {
 				panic(err)
 			}
+			// Projects are checked as they are over the API, so that an
+			// invalid file stops the controller rather than the reconcile loop
+			err = proj.Validate()
+			if err == nil {
+				_, err = scheduler.ForProject(proj)
+			}
+			if err != nil {
+				panic(fmt.Errorf("invalid project file %s: %w", f.Name(), err))
+			}
 			projects = append(projects, proj)
 		}
 
@@ -95,6 +116,10 @@ func startController() {
 				panic(err)
 			}
 		}
+
+		// Containers left from a lost state are adopted rather than started
+		// again
+		orch.AdoptServices()
 	} else {
 		log.Info("Previous state found. Recovering previous state.")
 		file, err := ioutil.ReadFile(viper.GetString("metis.home") + "/state.json")
@@ -110,6 +135,31 @@ func startController() {
 		}).Info("Recovered state")
 	}
 
+	backends := []routing.RouterBackend{}
+	for _, name := range strings.Split(viper.GetString("metis.routing.backends"), ",") {
+		backend, err := routing.New(strings.TrimSpace(name))
+		if err != nil {
+			panic(err)
+		}
+		backends = append(backends, backend)
+	}
+	orch.SetRouterBackends(backends)
+
+	err = orch.RefreshRoutes()
+	if err != nil {
+		panic(err)
+	}
+
+	if viper.GetString("metis.secrets.key") != "" {
+		store, err := secret.NewStore(viper.GetString("metis.home")+"/secrets.json", viper.GetString("metis.secrets.key"))
+		if err != nil {
+			panic(err)
+		}
+		orch.SetSecrets(store)
+	} else {
+		log.Info("No secrets key set. Secrets are disabled.")
+	}
+
 	go func() {
 		r := chi.NewRouter()
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
@@ -127,21 +177,36 @@ func startController() {
 
 		r.Get("/projects", func(w http.ResponseWriter, r *http.Request) {
 			projects := []struct {
-				Healthy int `json:"healthy"`
+				Healthy       int                  `json:"healthy"`
+				Status        status.ServiceStatus `json:"status,omitempty"`
+				Unschedulable string               `json:"unschedulable,omitempty"`
+				CreateError   string               `json:"create_error,omitempty"`
+				Deployment    *project.Deployment  `json:"deployment,omitempty"`
 				project.Project
 			}{}
-			for _, proj := range orch.Projects {
-				healthy, err := orch.CountHealthy(proj.Name)
+			snapshot := orch.Snapshot()
+			for _, proj := range snapshot.Projects {
+				healthy, err := snapshot.CountHealthy(proj.Name)
 				if err != nil {
 					log.WithError(err).Error("Could not return project")
 					continue
 				}
 				response := struct {
-					Healthy int `json:"healthy"`
+					Healthy       int                  `json:"healthy"`
+					Status        status.ServiceStatus `json:"status,omitempty"`
+					Unschedulable string               `json:"unschedulable,omitempty"`
+					CreateError   string               `json:"create_error,omitempty"`
+					Deployment    *project.Deployment  `json:"deployment,omitempty"`
 					project.Project
 				}{
-					Healthy: healthy,
-					Project: proj,
+					Healthy:       healthy,
+					Status:        snapshot.ProjectStatus(proj.Name),
+					Unschedulable: snapshot.Unschedulable[proj.Name],
+					CreateError:   snapshot.CreateErrors[proj.Name],
+					Project:       proj,
+				}
+				if deployment, ok := snapshot.GetDeployment(proj.Name); ok {
+					response.Deployment = &deployment
 				}
 				projects = append(projects, response)
 			}
@@ -152,8 +217,12 @@ func startController() {
 			}
 		})
 
+		registerProjectRoutes(r, &orch)
+		registerNodeRoutes(r, &orch)
+		registerSecretRoutes(r, &orch)
+
 		r.Get("/services", func(w http.ResponseWriter, r *http.Request) {
-			err := json.NewEncoder(w).Encode(orch.GetServices())
+			err := json.NewEncoder(w).Encode(orch.Snapshot().GetServices())
 			if err != nil {
 				log.WithError(err).Error("Could not send API response")
 				return
@@ -161,23 +230,35 @@ func startController() {
 		})
 
 		r.Get("/nodes", func(w http.ResponseWriter, r *http.Request) {
-			err := json.NewEncoder(w).Encode(orch.Nodes)
+			err := json.NewEncoder(w).Encode(orch.Snapshot().Nodes)
 			if err != nil {
 				log.WithError(err).Error("Could not send API response")
 				return
 			}
 		})
 
-		r.Get("/traefik", func(w http.ResponseWriter, r *http.Request) {
-			config := orch.GetTraefikConfig()
-
-			err := json.NewEncoder(w).Encode(config.ToMap())
+		r.Get("/orphans", func(w http.ResponseWriter, r *http.Request) {
+			err := json.NewEncoder(w).Encode(struct {
+				DryRun  bool                  `json:"dry_run"`
+				Orphans []orchestrator.Orphan `json:"orphans"`
+			}{
+				viper.GetBool("metis.gc.dry_run"),
+				orch.Snapshot().OrphanReport(),
+			})
 			if err != nil {
-				w.WriteHeader(500)
-				log.WithError(err).Error("Could not create traefik config")
+				log.WithError(err).Error("Could not send API response")
+				return
 			}
 		})
 
+		r.Get("/routing/{backend}", func(w http.ResponseWriter, r *http.Request) {
+			writeRouterConfig(w, r, &orch, chi.URLParam(r, "backend"))
+		})
+
+		r.Get("/traefik", func(w http.ResponseWriter, r *http.Request) {
+			writeRouterConfig(w, r, &orch, routing.TRAEFIK)
+		})
+
 		log.Info("Started API service")
 
 		err := http.ListenAndServe(":8060", r)
@@ -209,3 +290,72 @@ func startController() {
 	}
 
 }
+
+// loadControllerID sets metis.controller.id, which the agents label the
+// containers of this controller with, to the ID stored in metis.home,
+// generating one on first boot. Setting it explicitly keeps it when
+// metis.home is lost.
+func loadControllerID() error {
+	if viper.GetString("metis.controller.id") != "" {
+		return nil
+	}
+
+	location := viper.GetString("metis.home") + "/controller-id"
+	byts, err := ioutil.ReadFile(location)
+	if err == nil && strings.TrimSpace(string(byts)) != "" {
+		viper.Set("metis.controller.id", strings.TrimSpace(string(byts)))
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.MkdirAll(viper.GetString("metis.home"), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("controller-%s", uuid.NewString()[:8])
+	err = ioutil.WriteFile(location, []byte(id), 0644)
+	if err != nil {
+		return err
+	}
+	viper.Set("metis.controller.id", id)
+
+	return nil
+}
+
+// writeRouterConfig serves the cached configuration of a router backend,
+// or a 304 if the client already has it.
+func writeRouterConfig(w http.ResponseWriter, r *http.Request, orch *orchestrator.Orchestrator, backend string) {
+	config, etag, contentType, err := orch.RouterConfig(backend)
+	if err != nil {
+		w.WriteHeader(404)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(304)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, err = w.Write(config)
+	if err != nil {
+		log.WithError(err).Error("Could not send router config")
+	}
+}
+
+// matchesETag reports whether an If-None-Match header matches the ETag.
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
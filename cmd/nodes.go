@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"metis/internal/payload"
+	"metis/pkg/node"
+	"metis/pkg/orchestrator"
+	"net/http"
+
+	"github.com/Strum355/log"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func registerNodeRoutes(r chi.Router, orch *orchestrator.Orchestrator) {
+	r.With(tokenAuth).Post("/nodes/register", func(w http.ResponseWriter, r *http.Request) {
+		pload := payload.RegisterNodePayload{}
+		err := json.NewDecoder(r.Body).Decode(&pload)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(400)
+			log.WithError(err).Error("Could not decode payload")
+			return
+		}
+
+		nd := orch.RegisterNode(node.Node{
+			ID:       pload.ID,
+			Address:  pload.Address,
+			APIPort:  pload.APIPort,
+			Labels:   pload.Labels,
+			Capacity: pload.Capacity,
+		})
+
+		err = orch.WriteState()
+		if err != nil {
+			log.WithError(err).Error("Could not write state")
+		}
+
+		err = json.NewEncoder(w).Encode(payload.RegisterNodeResponsePayload{
+			ID: nd.ID,
+		})
+		if err != nil {
+			log.WithError(err).Error("Could not send API response")
+			return
+		}
+	})
+
+	r.With(tokenAuth).Post("/nodes/{id}/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		err := orch.Heartbeat(chi.URLParam(r, "id"))
+		if errors.Is(err, orchestrator.ErrNodeNotFound) {
+			w.WriteHeader(404)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+
+	r.With(tokenAuth).Post("/nodes/{id}/drain", func(w http.ResponseWriter, r *http.Request) {
+		setDraining(w, r, orch, true)
+	})
+
+	r.With(tokenAuth).Delete("/nodes/{id}/drain", func(w http.ResponseWriter, r *http.Request) {
+		setDraining(w, r, orch, false)
+	})
+}
+
+func setDraining(w http.ResponseWriter, r *http.Request, orch *orchestrator.Orchestrator, draining bool) {
+	err := orch.SetDraining(chi.URLParam(r, "id"), draining)
+	if errors.Is(err, orchestrator.ErrNodeNotFound) {
+		w.WriteHeader(404)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	err = orch.WriteState()
+	if err != nil {
+		log.WithError(err).Error("Could not write state")
+	}
+
+	w.WriteHeader(204)
+}
+
+// tokenAuth rejects requests that do not carry the shared metis secret.
+func tokenAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Token") != viper.GetString("metis.secret") {
+			w.WriteHeader(401)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
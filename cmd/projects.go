@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"metis/pkg/orchestrator"
+	"metis/pkg/project"
+	"metis/pkg/scheduler"
+	"net/http"
+
+	"github.com/Strum355/log"
+	"github.com/go-chi/chi/v5"
+)
+
+func registerProjectRoutes(r chi.Router, orch *orchestrator.Orchestrator) {
+	r.Get("/projects/{name}", func(w http.ResponseWriter, r *http.Request) {
+		proj, err := orch.Snapshot().GetProject(chi.URLParam(r, "name"))
+		if err != nil {
+			w.WriteHeader(404)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(proj)
+		if err != nil {
+			log.WithError(err).Error("Could not send API response")
+			return
+		}
+	})
+
+	r.Get("/projects/{name}/revisions", func(w http.ResponseWriter, r *http.Request) {
+		revisions, err := orch.Snapshot().GetRevisions(chi.URLParam(r, "name"))
+		if err != nil {
+			w.WriteHeader(404)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(revisions)
+		if err != nil {
+			log.WithError(err).Error("Could not send API response")
+			return
+		}
+	})
+
+	r.With(tokenAuth).Post("/projects/{name}/rollback", func(w http.ResponseWriter, r *http.Request) {
+		pload := struct {
+			Revision int `json:"revision"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&pload)
+		defer r.Body.Close()
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprint(w, err.Error())
+			log.WithError(err).Error("Could not decode payload")
+			return
+		}
+
+		name := chi.URLParam(r, "name")
+		err = orch.Rollback(name, pload.Revision)
+		if errors.Is(err, orchestrator.ErrProjectNotFound) || errors.Is(err, orchestrator.ErrRevisionNotFound) {
+			w.WriteHeader(404)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprint(w, err.Error())
+			log.WithError(err).Error("Could not roll back project")
+			return
+		}
+
+		writeProject(w, orch, project.Project{Name: name}, 200)
+	})
+
+	r.With(tokenAuth).Post("/projects/{name}/promote", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		deploymentAction(w, orch, name, orch.Promote(name))
+	})
+
+	r.With(tokenAuth).Post("/projects/{name}/abort", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		deploymentAction(w, orch, name, orch.Abort(name))
+	})
+
+	r.With(tokenAuth).Post("/projects/{name}", func(w http.ResponseWriter, r *http.Request) {
+		proj, ok := decodeProject(w, r)
+		if !ok {
+			return
+		}
+
+		err := orch.CreateProject(proj)
+		if errors.Is(err, orchestrator.ErrProjectExists) {
+			w.WriteHeader(409)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprint(w, err.Error())
+			log.WithError(err).Error("Could not create project")
+			return
+		}
+
+		writeProject(w, orch, proj, 201)
+	})
+
+	r.With(tokenAuth).Put("/projects/{name}", func(w http.ResponseWriter, r *http.Request) {
+		proj, ok := decodeProject(w, r)
+		if !ok {
+			return
+		}
+
+		err := orch.UpdateProject(proj)
+		if errors.Is(err, orchestrator.ErrProjectNotFound) {
+			w.WriteHeader(404)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprint(w, err.Error())
+			log.WithError(err).Error("Could not update project")
+			return
+		}
+
+		writeProject(w, orch, proj, 200)
+	})
+
+	r.With(tokenAuth).Delete("/projects/{name}", func(w http.ResponseWriter, r *http.Request) {
+		err := orch.DestroyProject(project.Project{Name: chi.URLParam(r, "name")})
+		if errors.Is(err, orchestrator.ErrProjectNotFound) {
+			w.WriteHeader(404)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprint(w, err.Error())
+			log.WithError(err).Error("Could not destroy project")
+			return
+		}
+
+		err = orch.WriteState()
+		if err != nil {
+			log.WithError(err).Error("Could not write state")
+		}
+
+		w.WriteHeader(204)
+	})
+}
+
+// deploymentAction writes the response to promoting or aborting a deployment.
+func deploymentAction(w http.ResponseWriter, orch *orchestrator.Orchestrator, name string, err error) {
+	if errors.Is(err, orchestrator.ErrProjectNotFound) {
+		w.WriteHeader(404)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if errors.Is(err, orchestrator.ErrNoDeployment) || errors.Is(err, orchestrator.ErrCandidateNotReady) {
+		w.WriteHeader(409)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprint(w, err.Error())
+		log.WithError(err).Error("Could not update deployment")
+		return
+	}
+
+	writeProject(w, orch, project.Project{Name: name}, 200)
+}
+
+// decodeProject reads a project from the request body, taking its name from
+// the URL. It writes an error response and returns false if the project is
+// invalid.
+func decodeProject(w http.ResponseWriter, r *http.Request) (project.Project, bool) {
+	proj := project.Project{}
+	err := json.NewDecoder(r.Body).Decode(&proj)
+	defer r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprint(w, err.Error())
+		log.WithError(err).Error("Could not decode payload")
+		return proj, false
+	}
+
+	name := chi.URLParam(r, "name")
+	if proj.Name != "" && proj.Name != name {
+		w.WriteHeader(400)
+		fmt.Fprint(w, "project name does not match URL")
+		return proj, false
+	}
+	proj.Name = name
+
+	err = proj.Validate()
+	if err == nil {
+		_, err = scheduler.ForProject(proj)
+	}
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprint(w, err.Error())
+		return proj, false
+	}
+
+	return proj, true
+}
+
+func writeProject(w http.ResponseWriter, orch *orchestrator.Orchestrator, proj project.Project, code int) {
+	err := orch.WriteState()
+	if err != nil {
+		log.WithError(err).Error("Could not write state")
+	}
+
+	// Return the project as stored, with its revision
+	proj, err = orch.Snapshot().GetProject(proj.Name)
+	if err != nil {
+		w.WriteHeader(404)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	w.WriteHeader(code)
+	err = json.NewEncoder(w).Encode(proj)
+	if err != nil {
+		log.WithError(err).Error("Could not send API response")
+		return
+	}
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"metis/pkg/orchestrator"
+	"metis/pkg/secret"
+	"net/http"
+
+	"github.com/Strum355/log"
+	"github.com/go-chi/chi/v5"
+)
+
+func registerSecretRoutes(r chi.Router, orch *orchestrator.Orchestrator) {
+	r.With(tokenAuth).Get("/secrets", func(w http.ResponseWriter, r *http.Request) {
+		secrets, err := orch.GetSecrets()
+		if err != nil {
+			writeSecretError(w, err)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(secrets)
+		if err != nil {
+			log.WithError(err).Error("Could not send API response")
+			return
+		}
+	})
+
+	r.With(tokenAuth).Post("/secrets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		value, ok := decodeSecret(w, r)
+		if !ok {
+			return
+		}
+
+		info, err := orch.CreateSecret(chi.URLParam(r, "name"), value)
+		if err != nil {
+			writeSecretError(w, err)
+			return
+		}
+
+		writeSecret(w, info, 201)
+	})
+
+	r.With(tokenAuth).Put("/secrets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		value, ok := decodeSecret(w, r)
+		if !ok {
+			return
+		}
+
+		info, err := orch.RotateSecret(chi.URLParam(r, "name"), value)
+		if err != nil {
+			writeSecretError(w, err)
+			return
+		}
+
+		err = orch.WriteState()
+		if err != nil {
+			log.WithError(err).Error("Could not write state")
+		}
+
+		writeSecret(w, info, 200)
+	})
+
+	r.With(tokenAuth).Delete("/secrets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		err := orch.DeleteSecret(chi.URLParam(r, "name"))
+		if err != nil {
+			writeSecretError(w, err)
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+}
+
+// decodeSecret reads the value of a secret from the request body, writing an
+// error response if it is missing.
+func decodeSecret(w http.ResponseWriter, r *http.Request) (string, bool) {
+	pload := struct {
+		Value string `json:"value"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&pload)
+	defer r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprint(w, err.Error())
+		log.WithError(err).Error("Could not decode payload")
+		return "", false
+	}
+	if pload.Value == "" {
+		w.WriteHeader(400)
+		fmt.Fprint(w, "secret value is required")
+		return "", false
+	}
+
+	return pload.Value, true
+}
+
+func writeSecret(w http.ResponseWriter, info secret.Info, code int) {
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(info)
+	if err != nil {
+		log.WithError(err).Error("Could not send API response")
+	}
+}
+
+func writeSecretError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, orchestrator.ErrSecretsDisabled):
+		w.WriteHeader(503)
+	case errors.Is(err, secret.ErrSecretNotFound):
+		w.WriteHeader(404)
+	case errors.Is(err, secret.ErrSecretExists), errors.Is(err, orchestrator.ErrSecretInUse):
+		w.WriteHeader(409)
+	default:
+		w.WriteHeader(500)
+		log.WithError(err).Error("Could not update secrets")
+	}
+	fmt.Fprint(w, err.Error())
+}
@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"metis/internal/payload"
+	"metis/pkg/provider"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Strum355/log"
+	"github.com/spf13/viper"
+)
+
+var (
+	errNotRegistered = errors.New("node not registered with controller")
+)
+
+// Registrar registers the agent with the controller and keeps it registered
+// by sending heartbeats.
+type Registrar struct {
+	id       string
+	client   http.Client
+	provider provider.Provider
+}
+
+func NewRegistrar(provider provider.Provider) Registrar {
+	r := Registrar{client: http.Client{Timeout: 10 * time.Second}, provider: provider}
+
+	byts, err := ioutil.ReadFile(idLocation())
+	if err == nil {
+		r.id = strings.TrimSpace(string(byts))
+	}
+
+	return r
+}
+
+// Run registers the agent and sends heartbeats forever. The agent
+// re-registers whenever the controller no longer knows it.
+func (r *Registrar) Run() {
+	interval := viper.GetDuration("metis.agent.heartbeat")
+	registered := false
+
+	for {
+		var err error
+		if registered {
+			err = r.heartbeat()
+			if errors.Is(err, errNotRegistered) {
+				registered = false
+				err = r.register()
+			}
+		} else {
+			err = r.register()
+		}
+
+		if err != nil {
+			log.WithError(err).Error("Could not reach controller")
+		} else {
+			registered = true
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func (r *Registrar) register() error {
+	address := viper.GetString("metis.agent.address")
+	if address == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		address = hostname
+	}
+
+	capacity, err := r.provider.Capacity(context.Background())
+	if err != nil {
+		log.WithError(err).Error("Could not get node capacity")
+	}
+
+	marshal, err := json.Marshal(payload.RegisterNodePayload{
+		ID:       r.id,
+		Address:  address,
+		APIPort:  viper.GetInt("metis.agent.port"),
+		Labels:   labels(),
+		Capacity: capacity,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.do("POST", "/nodes/register", marshal)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("controller returned status %d", resp.StatusCode)
+	}
+
+	respPload := payload.RegisterNodeResponsePayload{}
+	err = json.NewDecoder(resp.Body).Decode(&respPload)
+	if err != nil {
+		return err
+	}
+
+	if respPload.ID != r.id {
+		r.id = respPload.ID
+		err = r.persistID()
+		if err != nil {
+			log.WithError(err).Error("Could not persist node ID")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id": r.id,
+	}).Info("Registered with controller")
+
+	return nil
+}
+
+func (r *Registrar) heartbeat() error {
+	resp, err := r.do("POST", fmt.Sprintf("/nodes/%s/heartbeat", r.id), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return errNotRegistered
+	}
+	if resp.StatusCode != 204 {
+		return fmt.Errorf("controller returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (r *Registrar) do(method, path string, body []byte) (*http.Response, error) {
+	url := viper.GetString("metis.controller.url")
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(url, "/")+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Token", viper.GetString("metis.secret"))
+
+	return r.client.Do(req)
+}
+
+func (r *Registrar) persistID() error {
+	err := os.MkdirAll(viper.GetString("metis.home"), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(idLocation(), []byte(r.id), 0644)
+}
+
+func idLocation() string {
+	return viper.GetString("metis.home") + "/node-id"
+}
+
+// labels parses the comma separated metis.agent.labels setting.
+func labels() []string {
+	lbls := []string{}
+	for _, label := range strings.Split(viper.GetString("metis.agent.labels"), ",") {
+		label = strings.TrimSpace(label)
+		if label != "" {
+			lbls = append(lbls, label)
+		}
+	}
+
+	return lbls
+}
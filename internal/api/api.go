@@ -61,4 +61,7 @@ func (a *API) Register(r chi.Router) {
 	r.Post("/service", a.CreateService)
 	r.Post("/service/health", a.ServiceHealth)
 	r.Post("/service/destroy", a.DestroyService)
+	r.Post("/service/adopt", a.AdoptService)
+	r.Get("/services", a.ServiceStatuses)
+	r.Post("/services/health", a.ServicesHealth)
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"metis/internal/payload"
+	"metis/pkg/state"
 	"net/http"
 
 	"github.com/Strum355/log"
@@ -18,6 +19,8 @@ func (a *API) CreateService(w http.ResponseWriter, r *http.Request) {
 		log.WithError(err).Error("Could not decode payload")
 		return
 	}
+	pload.Service.SecretValues = pload.Secrets
+	pload.Service.Controller = pload.Controller
 
 	log.WithFields(log.Fields{
 		"service": pload.Service.Name(),
@@ -28,18 +31,23 @@ func (a *API) CreateService(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(500)
 		fmt.Fprint(w, err.Error())
 		log.WithError(err).Error("Could not create service")
+		return
 	}
 
 	log.WithFields(log.Fields{
 		"service": pload.Service.Name(),
 	}).Info("Starting service")
 
-	serviceState, err = a.serviceProvider.StartService(r.Context(), serviceState)
+	started, err := a.serviceProvider.StartService(r.Context(), serviceState)
 	if err != nil {
 		w.WriteHeader(500)
 		fmt.Fprint(w, err.Error())
 		log.WithError(err).Error("Could not start service")
+		// Do not leave the container behind
+		_, _ = a.serviceProvider.DestroyService(r.Context(), serviceState)
+		return
 	}
+	serviceState = started
 
 	err = json.NewEncoder(w).Encode(payload.CreateServiceResponsePayload{
 		ServiceState: serviceState,
@@ -71,6 +79,7 @@ func (a *API) ServiceHealth(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(500)
 		fmt.Fprint(w, err.Error())
 		log.WithError(err).Error("Could not get service status")
+		return
 	}
 
 	err = json.NewEncoder(w).Encode(payload.ServiceHealthResponsePayload{
@@ -84,6 +93,98 @@ func (a *API) ServiceHealth(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// ServicesHealth checks the health of a batch of services, so the controller
+// needs only one request per node.
+func (a *API) ServicesHealth(w http.ResponseWriter, r *http.Request) {
+	pload := payload.ServicesHealthPayload{}
+	err := json.NewDecoder(r.Body).Decode(&pload)
+	defer r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		log.WithError(err).Error("Could not decode payload")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"services": len(pload.Services),
+	}).Debug("Updating health of services")
+
+	response := payload.ServicesHealthResponsePayload{
+		States: make([]state.ServiceState, 0, len(pload.Services)),
+		Errors: map[string]string{},
+	}
+	for _, srv := range pload.Services {
+		serviceState, err := a.serviceProvider.ServiceHealth(r.Context(), srv)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"service": srv.Service.Name(),
+			}).Error("Could not get service status")
+			response.Errors[srv.ID] = err.Error()
+			serviceState = srv
+		}
+		response.States = append(response.States, serviceState)
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprint(w, err.Error())
+		log.WithError(err).Error("Could not return response")
+	}
+}
+
+// ServiceStatuses returns the status of every container on the node that is
+// managed by Metis.
+func (a *API) ServiceStatuses(w http.ResponseWriter, r *http.Request) {
+	statuses, err := a.serviceProvider.ServiceStatuses(r.Context())
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprint(w, err.Error())
+		log.WithError(err).Error("Could not list services")
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(payload.ServiceStatusesResponsePayload{
+		Services: statuses,
+	})
+	if err != nil {
+		log.WithError(err).Error("Could not return response")
+	}
+}
+
+// AdoptService takes over an existing container for a service the controller
+// has rebuilt from the node's containers.
+func (a *API) AdoptService(w http.ResponseWriter, r *http.Request) {
+	pload := payload.AdoptServicePayload{}
+	err := json.NewDecoder(r.Body).Decode(&pload)
+	defer r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		log.WithError(err).Error("Could not decode payload")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"service": pload.ServiceState.Service.Name(),
+		"id":      pload.ServiceState.ID,
+	}).Info("Adopting service")
+
+	serviceState, err := a.serviceProvider.AdoptService(r.Context(), pload.ServiceState)
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprint(w, err.Error())
+		log.WithError(err).Error("Could not adopt service")
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(payload.AdoptServiceResponsePayload{
+		ServiceState: serviceState,
+	})
+	if err != nil {
+		log.WithError(err).Error("Could not return response")
+	}
+}
+
 func (a *API) DestroyService(w http.ResponseWriter, r *http.Request) {
 	pload := payload.DestroyServicePayload{}
 	err := json.NewDecoder(r.Body).Decode(&pload)
@@ -103,6 +204,7 @@ func (a *API) DestroyService(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(500)
 		fmt.Fprint(w, err.Error())
 		log.WithError(err).Error("Could not stop service")
+		return
 	}
 
 	log.WithFields(log.Fields{
@@ -114,6 +216,7 @@ func (a *API) DestroyService(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(500)
 		fmt.Fprint(w, err.Error())
 		log.WithError(err).Error("Could not destroy service")
+		return
 	}
 
 	err = json.NewEncoder(w).Encode(payload.DestroyServiceResponsePayload{
@@ -1 +1,15 @@
 package payload
+
+import "metis/pkg/service"
+
+type RegisterNodePayload struct {
+	ID       string            `json:"id"`
+	Address  string            `json:"address"`
+	APIPort  int               `json:"api_port"`
+	Labels   []string          `json:"labels"`
+	Capacity service.Resources `json:"capacity"`
+}
+
+type RegisterNodeResponsePayload struct {
+	ID string `json:"id"`
+}
@@ -7,6 +7,10 @@ import (
 
 type CreateServicePayload struct {
 	Service service.DockerService `json:"service"`
+	// Secrets holds the values of the secrets the service references
+	Secrets map[string]string `json:"secrets"`
+	// Controller is the ID of the controller creating the service
+	Controller string `json:"controller"`
 }
 
 type CreateServiceResponsePayload struct {
@@ -21,6 +25,30 @@ type ServiceHealthResponsePayload struct {
 	ServiceState state.ServiceState `json:"state"`
 }
 
+type ServicesHealthPayload struct {
+	Services []state.ServiceState `json:"services"`
+}
+
+// ServicesHealthResponsePayload holds the states of the services in the order
+// they were requested. Errors holds why a service could not be checked,
+// keyed by service ID.
+type ServicesHealthResponsePayload struct {
+	States []state.ServiceState `json:"states"`
+	Errors map[string]string    `json:"errors,omitempty"`
+}
+
+type ServiceStatusesResponsePayload struct {
+	Services []state.ContainerStatus `json:"services"`
+}
+
+type AdoptServicePayload struct {
+	ServiceState state.ServiceState `json:"service"`
+}
+
+type AdoptServiceResponsePayload struct {
+	ServiceState state.ServiceState `json:"state"`
+}
+
 type DestroyServicePayload struct {
 	ServiceState state.ServiceState `json:"service"`
 }
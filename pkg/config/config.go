@@ -18,11 +18,46 @@ func loadDefaults() {
 	viper.SetDefault("metis.home", "/metis-data")
 	viper.SetDefault("metis.agent.port", "6060")
 	viper.SetDefault("metis.secret", "1oldmsmkp!")
-	viper.SetDefault("metis.controller.url", "localhost")
+	viper.SetDefault("metis.controller.url", "http://localhost:8060")
+	viper.SetDefault("metis.controller.id", "")
+	viper.SetDefault("metis.agent.address", "")
+	viper.SetDefault("metis.agent.labels", "")
+	viper.SetDefault("metis.agent.register", true)
+	viper.SetDefault("metis.agent.heartbeat", "5s")
+	viper.SetDefault("metis.agent.bind_allowlist", "")
+	viper.SetDefault("metis.agent.timeout", "10s")
+	viper.SetDefault("metis.agent.create_timeout", "5m")
+	viper.SetDefault("metis.controller.workers", 10)
+	viper.SetDefault("metis.node.heartbeat_timeout", "15s")
+	viper.SetDefault("metis.node.remove_after", "5m")
+	viper.SetDefault("metis.node.grace_period", "30s")
+	viper.SetDefault("metis.history.limit", 10)
+	viper.SetDefault("metis.restart.crashloop_threshold", 5)
+	viper.SetDefault("metis.restart.reset_after", "10m")
+	viper.SetDefault("metis.scheduler.strategy", "spread")
+	viper.SetDefault("metis.scheduler.spread_label", "")
+	viper.SetDefault("metis.secrets.key", "")
+	viper.SetDefault("metis.gc.interval", "1m")
+	viper.SetDefault("metis.gc.grace_period", "10m")
+	viper.SetDefault("metis.gc.dry_run", true)
+	viper.SetDefault("metis.routing.backends", "traefik")
+	viper.SetDefault("metis.routing.traefik.file", "")
+	viper.SetDefault("metis.routing.caddy.file", "")
+	viper.SetDefault("metis.routing.caddy.listen", ":80")
+	viper.SetDefault("metis.routing.caddy.admin_url", "")
+	viper.SetDefault("metis.routing.haproxy.file", "")
+	viper.SetDefault("metis.routing.haproxy.bind", "*:80")
+	viper.SetDefault("metis.routing.nginx.file", "")
 }
 
 func PrintSettings() {
 	settings := viper.AllSettings()
+	// Never log the key the secrets store is encrypted with
+	if metis, ok := settings["metis"].(map[string]interface{}); ok {
+		if secrets, ok := metis["secrets"].(map[string]interface{}); ok && secrets["key"] != "" {
+			secrets["key"] = "********"
+		}
+	}
 
 	out, _ := json.MarshalIndent(settings, "", "\t")
 	log.Debug("config:\n" + string(out))
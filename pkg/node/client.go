@@ -0,0 +1,103 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// client is shared by every node so that connections to agents are pooled
+// and reused between reconciliation loops.
+var client = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// do sends a request to the node's agent, encoding body as JSON if it is not
+// nil and decoding the response into response if it is not nil. The request
+// is cancelled if it takes longer than timeout.
+func (n Node) do(ctx context.Context, timeout time.Duration, method string, path string, body interface{}, response interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		marshal, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(marshal)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://%s:%d%s", n.Address, n.APIPort, path), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Token", viper.GetString("metis.secret"))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return statusError{code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+
+	if response != nil {
+		err = json.NewDecoder(resp.Body).Decode(response)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+// statusError is returned when an agent responds with an error status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("agent returned %d: %s", e.code, e.msg)
+}
+
+// isStatus reports whether err is an error response with the status code,
+// such as a 404 from an agent too old to have an endpoint.
+func isStatus(err error, code int) bool {
+	var statusErr statusError
+	return errors.As(err, &statusErr) && statusErr.code == code
+}
+
+// callTimeout is the deadline for quick calls to an agent.
+func callTimeout() time.Duration {
+	return viper.GetDuration("metis.agent.timeout")
+}
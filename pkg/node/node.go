@@ -1,14 +1,14 @@
 package node
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"metis/internal/payload"
 	"metis/pkg/service"
 	"metis/pkg/state"
-	"net/http"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -19,31 +19,75 @@ type Node struct {
 	Labels  []string `json:"labels"`
 	APIPort int      `json:"api_port"`
 	Healthy bool     `json:"healthy"`
+
+	// Registered nodes joined the cluster themselves and are kept healthy by
+	// heartbeats. Nodes loaded from the nodes directory are health checked
+	// by the controller instead.
+	Registered    bool      `json:"registered"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+
+	// UnhealthySince is the time the node was first seen unhealthy, and is
+	// zero while the node is healthy.
+	UnhealthySince time.Time `json:"unhealthy_since"`
+
+	// Draining nodes keep their services but are not given new ones.
+	Draining bool `json:"draining"`
+
+	// Capacity is reported by the agent. It is zero for nodes that have not
+	// reported it, which are not limited by capacity.
+	Capacity service.Resources `json:"capacity"`
 }
 
-func (n Node) CreateService(ctx context.Context, srv service.Service) (state.ServiceState, error) {
-	pload := payload.CreateServicePayload{
-		Service: srv.(service.DockerService),
+func (n Node) HasLabel(label string) bool {
+	for _, l := range n.Labels {
+		if l == label {
+			return true
+		}
 	}
 
-	marshal, err := json.Marshal(pload)
-	if err != nil {
-		return state.ServiceState{}, err
+	return false
+}
+
+// LabelValue returns the value of a key=value label on the node, or an empty
+// string if the node has no such label.
+func (n Node) LabelValue(key string) string {
+	for _, l := range n.Labels {
+		if strings.HasPrefix(l, key+"=") {
+			return strings.TrimPrefix(l, key+"=")
+		}
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s:%d/service", n.Address, n.APIPort), bytes.NewBuffer(marshal))
-	if err != nil {
-		return state.ServiceState{}, err
+	return ""
+}
+
+// SetHealthy updates the health of the node, tracking when it became
+// unhealthy.
+func (n *Node) SetHealthy(healthy bool) {
+	if healthy {
+		n.UnhealthySince = time.Time{}
+	} else if n.Healthy || n.UnhealthySince.IsZero() {
+		n.UnhealthySince = time.Now()
 	}
-	req.Header.Set("Token", viper.GetString("metis.secret"))
-	client := http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return state.ServiceState{}, err
+	n.Healthy = healthy
+}
+
+// Lost reports whether the node has been unhealthy for longer than the grace
+// period, after which its services are considered gone.
+func (n Node) Lost(gracePeriod time.Duration) bool {
+	return !n.Healthy && !n.UnhealthySince.IsZero() && time.Since(n.UnhealthySince) > gracePeriod
+}
+
+func (n Node) CreateService(ctx context.Context, srv service.Service) (state.ServiceState, error) {
+	dockerSrv := srv.(service.DockerService)
+	pload := payload.CreateServicePayload{
+		Service:    dockerSrv,
+		Secrets:    dockerSrv.SecretValues,
+		Controller: viper.GetString("metis.controller.id"),
 	}
 
+	// Creating a service may pull its image, so it is given longer
 	respPload := payload.CreateServiceResponsePayload{}
-	err = json.NewDecoder(resp.Body).Decode(&respPload)
+	err := n.do(ctx, viper.GetDuration("metis.agent.create_timeout"), "POST", "/service", pload, &respPload)
 	if err != nil {
 		return state.ServiceState{}, err
 	}
@@ -58,29 +102,96 @@ func (n Node) ServiceHealth(ctx context.Context, srv state.ServiceState) (state.
 		ServiceState: srv,
 	}
 
-	marshal, err := json.Marshal(pload)
+	respPload := payload.ServiceHealthResponsePayload{}
+	err := n.do(ctx, callTimeout(), "POST", "/service/health", pload, &respPload)
 	if err != nil {
 		return srv, err
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s:%d/service/health", n.Address, n.APIPort), bytes.NewBuffer(marshal))
+	return respPload.ServiceState, nil
+}
+
+// ServicesHealth updates the health of many services on the node, usually in
+// a single request for the status of every container on the node. Services
+// that are not in it, or whose probes the agent is not running, are checked
+// individually in one more request. Services the agent could not check are
+// returned with an error, keyed by service ID.
+func (n Node) ServicesHealth(ctx context.Context, srvs []state.ServiceState) ([]state.ServiceState, map[string]error, error) {
+	statuses, err := n.ServiceStatuses(ctx)
+	if err != nil && !isStatus(err, 404) {
+		return srvs, nil, err
+	}
+
+	byID := map[string]state.ContainerStatus{}
+	for _, cs := range statuses {
+		byID[cs.ID] = cs
+	}
+
+	updated := make([]state.ServiceState, len(srvs))
+	unknown := []int{}
+	for i, srv := range srvs {
+		cs, ok := byID[srv.ID]
+		if !ok || (cs.Running() && !cs.Probed) {
+			unknown = append(unknown, i)
+			continue
+		}
+		updated[i] = cs.Apply(srv)
+	}
+
+	errs := map[string]error{}
+	if len(unknown) == 0 {
+		return updated, errs, nil
+	}
+
+	checked := []state.ServiceState{}
+	for _, i := range unknown {
+		checked = append(checked, srvs[i])
+	}
+	checked, errs, err = n.checkServices(ctx, checked)
 	if err != nil {
-		return srv, err
+		return srvs, nil, err
+	}
+	for j, i := range unknown {
+		updated[i] = checked[j]
 	}
-	req.Header.Set("Token", viper.GetString("metis.secret"))
-	client := http.Client{}
-	resp, err := client.Do(req)
+
+	return updated, errs, nil
+}
+
+// ServiceStatuses returns the status of every container on the node that is
+// managed by Metis.
+func (n Node) ServiceStatuses(ctx context.Context) ([]state.ContainerStatus, error) {
+	respPload := payload.ServiceStatusesResponsePayload{}
+	err := n.do(ctx, callTimeout(), "GET", "/services", nil, &respPload)
 	if err != nil {
-		return srv, err
+		return nil, err
 	}
 
-	respPload := payload.ServiceHealthResponsePayload{}
-	err = json.NewDecoder(resp.Body).Decode(&respPload)
+	return respPload.Services, nil
+}
+
+// checkServices updates the health of many services in a single request,
+// which also restarts the probes of services the agent has lost track of.
+func (n Node) checkServices(ctx context.Context, srvs []state.ServiceState) ([]state.ServiceState, map[string]error, error) {
+	pload := payload.ServicesHealthPayload{
+		Services: srvs,
+	}
+
+	respPload := payload.ServicesHealthResponsePayload{}
+	err := n.do(ctx, callTimeout(), "POST", "/services/health", pload, &respPload)
 	if err != nil {
-		return srv, err
+		return srvs, nil, err
+	}
+	if len(respPload.States) != len(srvs) {
+		return srvs, nil, fmt.Errorf("agent returned %d states for %d services", len(respPload.States), len(srvs))
 	}
 
-	return respPload.ServiceState, nil
+	errs := map[string]error{}
+	for id, msg := range respPload.Errors {
+		errs[id] = errors.New(msg)
+	}
+
+	return respPload.States, errs, nil
 }
 
 func (n Node) DestroyService(ctx context.Context, srv state.ServiceState) (state.ServiceState, error) {
@@ -88,27 +199,34 @@ func (n Node) DestroyService(ctx context.Context, srv state.ServiceState) (state
 		ServiceState: srv,
 	}
 
-	marshal, err := json.Marshal(pload)
+	respPload := payload.DestroyServiceResponsePayload{}
+	err := n.do(ctx, callTimeout(), "POST", "/service/destroy", pload, &respPload)
 	if err != nil {
 		return state.ServiceState{}, err
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s:%d/service/destroy", n.Address, n.APIPort), bytes.NewBuffer(marshal))
-	if err != nil {
-		return state.ServiceState{}, err
-	}
-	req.Header.Set("Token", viper.GetString("metis.secret"))
-	client := http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return state.ServiceState{}, err
+	return respPload.ServiceState, nil
+}
+
+// AdoptService hands a service the controller has rebuilt from the node's
+// containers back to the agent, which starts its probes.
+func (n Node) AdoptService(ctx context.Context, srv state.ServiceState) (state.ServiceState, error) {
+	pload := payload.AdoptServicePayload{
+		ServiceState: srv,
 	}
 
-	respPload := payload.DestroyServiceResponsePayload{}
-	err = json.NewDecoder(resp.Body).Decode(&respPload)
+	respPload := payload.AdoptServiceResponsePayload{}
+	err := n.do(ctx, callTimeout(), "POST", "/service/adopt", pload, &respPload)
 	if err != nil {
-		return state.ServiceState{}, err
+		return srv, err
 	}
 
+	respPload.ServiceState.Node = n.ID
+
 	return respPload.ServiceState, nil
 }
+
+// Ping checks that the node's agent is reachable.
+func (n Node) Ping(ctx context.Context) error {
+	return n.do(ctx, callTimeout(), "GET", "/", nil, nil)
+}
@@ -0,0 +1,205 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"metis/pkg/node"
+	"metis/pkg/project"
+	"metis/pkg/service"
+	"metis/pkg/state"
+	"metis/pkg/status"
+
+	"github.com/Strum355/log"
+	"github.com/spf13/viper"
+)
+
+// AdoptServices rebuilds the services of every healthy node from the
+// containers this controller created on them, such as after state.json was
+// lost. Nodes that cannot be reached are retried by the following updates.
+func (o *Orchestrator) AdoptServices() {
+	o.guard.update.Lock()
+	defer o.guard.update.Unlock()
+
+	o.withLock(func() {
+		for id, nd := range o.Nodes {
+			if nd.Healthy {
+				o.adopting[id] = true
+			}
+		}
+	})
+
+	o.adoptPending(context.Background())
+}
+
+// adoption is a container on a node to be adopted as a service of a project.
+type adoption struct {
+	project string
+	node    node.Node
+	srv     state.ServiceState
+
+	adopted state.ServiceState
+	err     error
+}
+
+// adoptPending adopts the services of nodes that registered since the last
+// update, retrying on the next update if the node could not be reached. The
+// containers on each node are listed and adopted without the state lock.
+func (o *Orchestrator) adoptPending(ctx context.Context) {
+	controllerID := viper.GetString("metis.controller.id")
+	nodes := []node.Node{}
+	o.withLock(func() {
+		for id := range o.adopting {
+			nd, ok := o.Nodes[id]
+			if !ok || controllerID == "" {
+				delete(o.adopting, id)
+				continue
+			}
+			if nd.Healthy {
+				nodes = append(nodes, nd)
+			}
+		}
+	})
+	if len(nodes) == 0 {
+		return
+	}
+
+	statuses := make([][]state.ContainerStatus, len(nodes))
+	errs := make([]error, len(nodes))
+	forEach(len(nodes), func(i int) {
+		statuses[i], errs[i] = nodes[i].ServiceStatuses(ctx)
+	})
+
+	adoptions := []adoption{}
+	o.withLock(func() {
+		for i, nd := range nodes {
+			if errs[i] != nil {
+				log.WithError(errs[i]).WithFields(log.Fields{
+					"node": nd.ID,
+				}).Error("Could not list services to adopt")
+				continue
+			}
+			adoptions = append(adoptions, o.adoptions(nd, statuses[i], controllerID)...)
+		}
+	})
+
+	forEach(len(adoptions), func(i int) {
+		adoptions[i].adopted, adoptions[i].err = adoptions[i].node.AdoptService(ctx, adoptions[i].srv)
+	})
+
+	defer o.lock()()
+	known := o.knownServices()
+	for _, a := range adoptions {
+		if a.err != nil {
+			log.WithError(a.err).WithFields(log.Fields{
+				"node": a.node.ID,
+				"id":   a.srv.ID,
+			}).Error("Could not adopt service")
+			continue
+		}
+		if _, err := o.GetProject(a.project); err != nil || known[a.srv.ID] {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"node":     a.node.ID,
+			"id":       a.srv.ID,
+			"project":  a.project,
+			"revision": a.srv.Service.Revision,
+		}).Info("Adopted service")
+		o.ProjectServices[a.project] = append(o.ProjectServices[a.project], a.adopted)
+		known[a.srv.ID] = true
+	}
+	for i, nd := range nodes {
+		if errs[i] == nil {
+			delete(o.adopting, nd.ID)
+		}
+	}
+}
+
+// knownServices returns the IDs of every service in the state.
+func (o *Orchestrator) knownServices() map[string]bool {
+	known := map[string]bool{}
+	for _, services := range o.ProjectServices {
+		for _, srv := range services {
+			known[srv.ID] = true
+		}
+	}
+
+	return known
+}
+
+// adoptions returns the running containers on a node that were created by
+// this controller for a known project, and that the controller has lost
+// track of, as services of their project. Adopted services get the
+// configuration of the revision they run, and services that run no known
+// configuration are rolled over as usual.
+func (o *Orchestrator) adoptions(nd node.Node, statuses []state.ContainerStatus, controllerID string) []adoption {
+	known := o.knownServices()
+	adoptions := []adoption{}
+	for _, cs := range statuses {
+		if cs.Controller != controllerID || known[cs.ID] || !cs.Running() {
+			continue
+		}
+		proj, err := o.GetProject(cs.Project)
+		if err != nil {
+			continue
+		}
+
+		srv := adoptedService(proj, o.Revisions[proj.Name], cs)
+
+		exposed := map[string]int32{}
+		for _, port := range srv.PublishedPorts() {
+			protocol := service.TCP
+			if port.Protocol == service.UDP {
+				protocol = service.UDP
+			}
+			if nodePort, ok := cs.Ports[fmt.Sprintf("%d/%s", port.ContainerPort, protocol)]; ok {
+				exposed[port.Name] = nodePort
+			}
+		}
+
+		adoptions = append(adoptions, adoption{
+			project: proj.Name,
+			node:    nd,
+			srv: state.ServiceState{
+				Status:       status.RUNNING,
+				Service:      srv,
+				Name:         cs.Name,
+				ID:           cs.ID,
+				ExposedPort:  exposed[srv.PublishedPorts()[0].Name],
+				ExposedPorts: exposed,
+				Node:         nd.ID,
+			},
+		})
+	}
+
+	return adoptions
+}
+
+// adoptedService returns the service a container of a project runs. The
+// revision the container is labelled with is only kept if it was created with
+// the configuration recorded for that revision, as revisions start over when
+// the state is lost. Other containers are given revision 0, which is never
+// current, so that they are replaced.
+func adoptedService(proj project.Project, revisions []project.Revision, cs state.ContainerStatus) service.DockerService {
+	current := project.Revision{Revision: proj.Revision, Configuration: proj.Configuration}
+	for _, rev := range append([]project.Revision{current}, revisions...) {
+		if rev.Revision != cs.Revision {
+			continue
+		}
+
+		revProj := proj
+		revProj.Revision = rev.Revision
+		revProj.Configuration = rev.Configuration
+		srv := serviceFromProject(revProj)
+		if srv.Spec() == cs.Spec {
+			return srv
+		}
+	}
+
+	srv := serviceFromProject(proj)
+	srv.Revision = 0
+	srv.DockerImage = cs.Image
+
+	return srv
+}
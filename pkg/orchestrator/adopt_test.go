@@ -0,0 +1,72 @@
+package orchestrator
+
+import (
+	"metis/pkg/project"
+	"metis/pkg/state"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestAdoptServices(t *testing.T) {
+	agent := newStubAgent(t)
+	o := newTestOrchestrator(t, agent)
+	web := project.Project{
+		Name:          "web",
+		Configuration: project.ProjectConfiguration{ImageName: "web:2", Count: 2},
+	}
+	if err := o.CreateProject(web); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	proj, _ := o.GetProject("web")
+
+	// Both containers are labelled with revision 1, but only one runs the
+	// configuration the project was recreated with after the state was lost
+	old := proj
+	old.Configuration.ImageName = "web:1"
+	containers := map[string]project.Project{"current": proj, "stale": old}
+	for id, p := range containers {
+		srv := serviceFromProject(p)
+		agent.run(state.ContainerStatus{
+			ID:         id,
+			Project:    "web",
+			Revision:   1,
+			Controller: viper.GetString("metis.controller.id"),
+			Spec:       srv.Spec(),
+			Image:      srv.DockerImage,
+			State:      "running",
+			Probed:     true,
+			Live:       true,
+			Ready:      true,
+		})
+	}
+
+	o.AdoptServices()
+
+	revisions := map[string]int{}
+	for _, srv := range o.Snapshot().ProjectServices["web"] {
+		revisions[srv.ID] = srv.Service.Revision
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("adopted %v, want current and stale", revisions)
+	}
+	if revisions["current"] != proj.Revision {
+		t.Errorf("current container was adopted at revision %d, want %d", revisions["current"], proj.Revision)
+	}
+	if revisions["stale"] == proj.Revision {
+		t.Errorf("stale container was adopted at the current revision %d", proj.Revision)
+	}
+
+	// The stale container is replaced, and the current one kept
+	for i := 0; i < 5 && agent.has("stale"); i++ {
+		if err := o.Update(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if agent.has("stale") {
+		t.Error("stale container was not replaced")
+	}
+	if !agent.has("current") {
+		t.Error("current container was replaced")
+	}
+}
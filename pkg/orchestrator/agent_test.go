@@ -0,0 +1,187 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"metis/internal/payload"
+	"metis/pkg/node"
+	"metis/pkg/state"
+	"metis/pkg/status"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// stubAgent is an agent that runs its containers in memory.
+type stubAgent struct {
+	mu         sync.Mutex
+	containers map[string]state.ContainerStatus
+	created    int
+	// failCreate holds the projects whose services the agent fails to create
+	failCreate map[string]bool
+	// failStatuses fails every request for the status of services
+	failStatuses bool
+	// creating is sent to when a create request arrives, which then waits
+	// for release, if they are set before the agent is used
+	creating chan struct{}
+	release  chan struct{}
+
+	server *httptest.Server
+}
+
+func newStubAgent(t *testing.T) *stubAgent {
+	a := &stubAgent{
+		containers: make(map[string]state.ContainerStatus),
+		failCreate: make(map[string]bool),
+	}
+	a.server = httptest.NewServer(http.HandlerFunc(a.serveHTTP))
+	t.Cleanup(a.server.Close)
+
+	return a
+}
+
+// node returns a node served by the agent.
+func (a *stubAgent) node(id string) node.Node {
+	host, port, _ := net.SplitHostPort(a.server.Listener.Addr().String())
+	apiPort, _ := strconv.Atoi(port)
+
+	return node.Node{ID: id, Address: host, APIPort: apiPort, Healthy: true}
+}
+
+func (a *stubAgent) setFailCreate(project string, fail bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failCreate[project] = fail
+}
+
+func (a *stubAgent) setFailStatuses(fail bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failStatuses = fail
+}
+
+// run adds a container to the agent, as if it had been created before the
+// controller lost its state.
+func (a *stubAgent) run(cs state.ContainerStatus) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.containers[cs.ID] = cs
+}
+
+// has reports whether the agent runs a container.
+func (a *stubAgent) has(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.containers[id]
+	return ok
+}
+
+// count returns how many containers the agent runs.
+func (a *stubAgent) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.containers)
+}
+
+func (a *stubAgent) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/service" && a.creating != nil {
+		a.creating <- struct{}{}
+		<-a.release
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var resp interface{}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /":
+		return
+	case "POST /service":
+		var pload payload.CreateServicePayload
+		if err := json.NewDecoder(r.Body).Decode(&pload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if a.failCreate[pload.Service.SrvName] {
+			http.Error(w, "no such image", http.StatusInternalServerError)
+			return
+		}
+
+		a.created++
+		id := fmt.Sprintf("container-%d", a.created)
+		a.containers[id] = state.ContainerStatus{
+			ID:         id,
+			Project:    pload.Service.SrvName,
+			Revision:   pload.Service.Revision,
+			Controller: pload.Controller,
+			Spec:       pload.Service.Spec(),
+			Image:      pload.Service.DockerImage,
+			State:      "running",
+			Probed:     true,
+			Live:       true,
+			Ready:      true,
+		}
+		resp = payload.CreateServiceResponsePayload{ServiceState: state.ServiceState{
+			ID:      id,
+			Name:    pload.Service.SrvName,
+			Status:  status.RUNNING,
+			Service: pload.Service,
+			Ready:   true,
+		}}
+	case "GET /services":
+		if a.failStatuses {
+			http.Error(w, "docker unavailable", http.StatusInternalServerError)
+			return
+		}
+		statuses := []state.ContainerStatus{}
+		for _, cs := range a.containers {
+			statuses = append(statuses, cs)
+		}
+		resp = payload.ServiceStatusesResponsePayload{Services: statuses}
+	case "POST /services/health":
+		if a.failStatuses {
+			http.Error(w, "docker unavailable", http.StatusInternalServerError)
+			return
+		}
+		var pload payload.ServicesHealthPayload
+		if err := json.NewDecoder(r.Body).Decode(&pload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respPload := payload.ServicesHealthResponsePayload{Errors: map[string]string{}}
+		for _, srv := range pload.Services {
+			cs, ok := a.containers[srv.ID]
+			if !ok {
+				respPload.Errors[srv.ID] = "no such container"
+			} else {
+				srv = cs.Apply(srv)
+			}
+			respPload.States = append(respPload.States, srv)
+		}
+		resp = respPload
+	case "POST /service/destroy":
+		var pload payload.DestroyServicePayload
+		if err := json.NewDecoder(r.Body).Decode(&pload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		delete(a.containers, pload.ServiceState.ID)
+		pload.ServiceState.Status = status.STOPPED
+		resp = payload.DestroyServiceResponsePayload{ServiceState: pload.ServiceState}
+	case "POST /service/adopt":
+		var pload payload.AdoptServicePayload
+		if err := json.NewDecoder(r.Body).Decode(&pload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp = payload.AdoptServiceResponsePayload{ServiceState: pload.ServiceState}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,208 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"metis/pkg/project"
+	"time"
+
+	"github.com/Strum355/log"
+)
+
+var (
+	ErrNoDeployment      = errors.New("no deployment in progress")
+	ErrCandidateNotReady = errors.New("candidate revision is not fully running")
+)
+
+// GetDeployment returns the canary or blue/green deployment of a project, if
+// one is in progress.
+func (o *Orchestrator) GetDeployment(name string) (project.Deployment, bool) {
+	deployment, ok := o.Deployments[name]
+	return deployment, ok
+}
+
+// startDeployment begins a canary or blue/green deployment when a project
+// using one of those strategies is given a new revision. A deployment that is
+// still in progress keeps its stable revision and swaps its candidate.
+func (o *Orchestrator) startDeployment(previous project.Project, proj project.Project) {
+	strategy := proj.Configuration.UpdateStrategy.Type
+	if strategy != project.CANARY && strategy != project.BLUEGREEN {
+		delete(o.Deployments, proj.Name)
+		return
+	}
+
+	deployment, ok := o.Deployments[proj.Name]
+	if !ok || deployment.Promoted() {
+		deployment = project.Deployment{
+			Stable:              previous.Revision,
+			StableConfiguration: previous.Configuration,
+		}
+	}
+	deployment.Strategy = strategy
+	deployment.Candidate = proj.Revision
+
+	log.WithFields(log.Fields{
+		"name":      proj.Name,
+		"strategy":  strategy,
+		"stable":    deployment.Stable,
+		"candidate": deployment.Candidate,
+	}).Info("Starting deployment")
+	o.Deployments[proj.Name] = deployment
+}
+
+// Promote finishes the deployment of a project's candidate revision. The rest
+// of a canary's services are rolled over to the candidate, while blue/green
+// deployments switch all traffic to the candidate at once and keep the
+// previous services for a quick revert.
+func (o *Orchestrator) Promote(name string) error {
+	defer o.lock()()
+
+	proj, err := o.GetProject(name)
+	if err != nil {
+		return err
+	}
+
+	deployment, ok := o.Deployments[name]
+	if !ok || deployment.Promoted() {
+		return ErrNoDeployment
+	}
+
+	log.WithFields(log.Fields{
+		"name":      name,
+		"strategy":  deployment.Strategy,
+		"candidate": deployment.Candidate,
+	}).Info("Promoting deployment")
+
+	if deployment.Strategy == project.CANARY {
+		delete(o.Deployments, name)
+		return nil
+	}
+
+	running := 0
+	for _, srv := range o.ProjectServices[name] {
+		if srv.Service.Revision == deployment.Candidate && isAvailable(srv, 0) {
+			running++
+		}
+	}
+	if running < proj.Configuration.Count {
+		return ErrCandidateNotReady
+	}
+
+	o.Deployments[name] = project.Deployment{
+		Strategy:              deployment.Strategy,
+		Stable:                deployment.Candidate,
+		StableConfiguration:   proj.Configuration,
+		Previous:              deployment.Stable,
+		PreviousConfiguration: deployment.StableConfiguration,
+		PromotedAt:            time.Now(),
+	}
+
+	return nil
+}
+
+// Abort returns a project to the revision that was receiving traffic before
+// the deployment, or before the promotion of a blue/green deployment. The
+// candidate's services are then removed as usual. The abort is recorded in
+// the project's history as an entry for the revision returned to.
+func (o *Orchestrator) Abort(name string) error {
+	defer o.lock()()
+
+	deployment, ok := o.Deployments[name]
+	if !ok {
+		return ErrNoDeployment
+	}
+
+	revision, configuration := deployment.Stable, deployment.StableConfiguration
+	if deployment.Promoted() {
+		revision, configuration = deployment.Previous, deployment.PreviousConfiguration
+	}
+
+	for i := range o.Projects {
+		if o.Projects[i].Name != name {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"name":     name,
+			"strategy": deployment.Strategy,
+			"revision": revision,
+		}).Info("Aborting deployment")
+		aborted := o.Projects[i].Revision
+		o.Projects[i].Configuration = configuration
+		o.Projects[i].Revision = revision
+		o.recordRevision(o.Projects[i], fmt.Sprintf("deployment of revision %d aborted", aborted))
+		delete(o.Deployments, name)
+		delete(o.Restarts, name)
+
+		return nil
+	}
+
+	return ErrProjectNotFound
+}
+
+// reconcileDeployment moves the services of a project with a deployment in
+// progress towards the counts of each revision the deployment runs.
+func (o *Orchestrator) reconcileDeployment(proj project.Project, deployment project.Deployment, plan *updatePlan) {
+	strategy := proj.Configuration.UpdateStrategy
+	stable := project.Project{
+		Name:          proj.Name,
+		Configuration: deployment.StableConfiguration,
+		Revision:      deployment.Stable,
+	}
+
+	targets := []revisionTarget{}
+	switch {
+	case deployment.Promoted():
+		delay := time.Duration(strategy.ScaleDownDelaySeconds) * time.Second
+		if delay > 0 && time.Since(deployment.PromotedAt) > delay {
+			log.WithFields(log.Fields{
+				"name":     proj.Name,
+				"previous": deployment.Previous,
+			}).Info("Scaling down previous revision")
+			delete(o.Deployments, proj.Name)
+			o.reconcileProject(proj, plan)
+			return
+		}
+
+		targets = append(targets,
+			revisionTarget{project: proj, count: proj.Configuration.Count},
+			revisionTarget{project: project.Project{Revision: deployment.Previous}, count: -1},
+		)
+	case deployment.Strategy == project.CANARY:
+		canaries := strategy.CanaryCount
+		if canaries == 0 {
+			canaries = 1
+		}
+
+		targets = append(targets,
+			revisionTarget{project: stable, count: stable.Configuration.Count},
+			revisionTarget{project: proj, count: canaries},
+		)
+	default:
+		targets = append(targets,
+			revisionTarget{project: stable, count: stable.Configuration.Count},
+			revisionTarget{project: proj, count: proj.Configuration.Count},
+		)
+	}
+
+	o.reconcileRevisions(proj.Name, targets, plan)
+}
+
+// trafficWeights returns the percentage of a project's traffic each revision
+// receives, or nil if traffic is not split by revision.
+func (o *Orchestrator) trafficWeights(proj project.Project) map[int]int {
+	deployment, ok := o.Deployments[proj.Name]
+	if !ok {
+		return nil
+	}
+
+	if deployment.Strategy == project.CANARY && !deployment.Promoted() {
+		weight := proj.Configuration.UpdateStrategy.CanaryWeight
+		return map[int]int{
+			deployment.Stable:    100 - weight,
+			deployment.Candidate: weight,
+		}
+	}
+
+	return map[int]int{deployment.Stable: 100}
+}
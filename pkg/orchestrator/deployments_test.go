@@ -0,0 +1,45 @@
+package orchestrator
+
+import (
+	"metis/pkg/project"
+	"testing"
+)
+
+func TestAbortRecordsRevision(t *testing.T) {
+	stable := project.ProjectConfiguration{ImageName: "web:1", Count: 2}
+	candidate := project.ProjectConfiguration{ImageName: "web:2", Count: 2}
+
+	o := NewOrchestrator()
+	o.Projects = []project.Project{{Name: "web", Revision: 2, Configuration: candidate}}
+	o.Revisions["web"] = []project.Revision{
+		{Revision: 1, Configuration: stable, Reason: "created"},
+		{Revision: 2, Configuration: candidate, Reason: "updated"},
+	}
+	o.Deployments["web"] = project.Deployment{
+		Strategy:            project.CANARY,
+		Stable:              1,
+		StableConfiguration: stable,
+		Candidate:           2,
+	}
+
+	err := o.Abort("web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	proj, _ := o.GetProject("web")
+	if proj.Revision != 1 || proj.Configuration.ImageName != "web:1" {
+		t.Errorf("project is at revision %d with image %s, want revision 1", proj.Revision, proj.Configuration.ImageName)
+	}
+
+	revisions := o.Revisions["web"]
+	latest := revisions[len(revisions)-1]
+	if latest.Revision != 1 || latest.Reason != "deployment of revision 2 aborted" {
+		t.Errorf("latest revision is %d (%q), want the abort of revision 2", latest.Revision, latest.Reason)
+	}
+
+	// Revision numbers are not reused after an abort
+	if next := o.nextRevision(proj); next != 3 {
+		t.Errorf("next revision is %d, want 3", next)
+	}
+}
@@ -0,0 +1,26 @@
+package orchestrator
+
+import (
+	"metis/pkg/config"
+	"os"
+	"testing"
+
+	"github.com/Strum355/log"
+	"github.com/spf13/viper"
+)
+
+func TestMain(m *testing.M) {
+	config.Load()
+	log.InitSimpleLogger(&log.Config{})
+
+	home, err := os.MkdirTemp("", "metis-test")
+	if err != nil {
+		panic(err)
+	}
+	viper.Set("metis.home", home)
+	viper.Set("metis.controller.id", "test-controller")
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
@@ -0,0 +1,228 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"metis/pkg/node"
+	"metis/pkg/scheduler"
+	"metis/pkg/state"
+	"metis/pkg/status"
+	"time"
+
+	"github.com/Strum355/log"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrNodeNotFound = errors.New("node not found")
+)
+
+// RegisterNode adds a node that announced itself to the controller, or
+// refreshes it if it is already known. Nodes registering without an ID are
+// matched against existing nodes by address, otherwise a new ID is assigned.
+// The registered node is returned with its ID set. Services this controller
+// created on a node it did not know are adopted.
+func (o *Orchestrator) RegisterNode(nd node.Node) node.Node {
+	defer o.lock()()
+
+	if _, ok := o.Nodes[nd.ID]; !ok || nd.ID == "" {
+		nd.ID = ""
+		for _, existing := range o.Nodes {
+			if existing.Address == nd.Address && existing.APIPort == nd.APIPort {
+				nd.ID = existing.ID
+				break
+			}
+		}
+	}
+	if nd.ID == "" {
+		nd.ID = fmt.Sprintf("node-%s", uuid.NewString()[:8])
+	}
+
+	// Keep what the controller knows about the node, such as draining
+	if existing, ok := o.Nodes[nd.ID]; ok {
+		existing.Address = nd.Address
+		existing.APIPort = nd.APIPort
+		existing.Labels = nd.Labels
+		existing.Capacity = nd.Capacity
+		nd = existing
+	}
+
+	_, known := o.Nodes[nd.ID]
+	nd.Registered = true
+	nd.SetHealthy(true)
+	nd.LastHeartbeat = time.Now()
+	o.Nodes[nd.ID] = nd
+
+	log.WithFields(log.Fields{
+		"node":    nd.ID,
+		"address": nd.Address,
+	}).Info("Node registered")
+
+	if !known {
+		// The agent may not be serving yet, so adopt on the next update
+		o.adopting[nd.ID] = true
+	}
+
+	return nd
+}
+
+// Heartbeat marks a registered node as alive.
+func (o *Orchestrator) Heartbeat(id string) error {
+	defer o.lock()()
+
+	nd, ok := o.Nodes[id]
+	if !ok {
+		return ErrNodeNotFound
+	}
+
+	if !nd.Healthy {
+		log.WithFields(log.Fields{
+			"node": nd.ID,
+		}).Info("Node healthy again")
+	}
+
+	nd.Registered = true
+	nd.SetHealthy(true)
+	nd.LastHeartbeat = time.Now()
+	o.Nodes[id] = nd
+
+	return nil
+}
+
+// checkHeartbeats marks registered nodes whose heartbeats have lapsed as
+// unhealthy, and removes them once they have been gone for long enough and
+// no services are left on them.
+func (o *Orchestrator) checkHeartbeats() {
+	timeout := viper.GetDuration("metis.node.heartbeat_timeout")
+	removeAfter := viper.GetDuration("metis.node.remove_after")
+
+	for id, nd := range o.Nodes {
+		if !nd.Registered {
+			continue
+		}
+
+		since := time.Since(nd.LastHeartbeat)
+		if since > removeAfter && !o.hasServices(id) {
+			log.WithFields(log.Fields{
+				"node": id,
+			}).Info("Removing node")
+			delete(o.Nodes, id)
+			delete(o.LostServices, id)
+			continue
+		}
+
+		if since > timeout && nd.Healthy {
+			log.WithFields(log.Fields{
+				"node":           id,
+				"last_heartbeat": nd.LastHeartbeat,
+			}).Info("Node missed heartbeat, marking unhealthy")
+			nd.SetHealthy(false)
+			o.Nodes[id] = nd
+		}
+	}
+}
+
+// hasServices reports whether a node has services that have not ended.
+// Ended services were destroyed when they ended, and are not checked again,
+// so they do not keep a node around.
+func (o *Orchestrator) hasServices(nodeID string) bool {
+	for _, services := range o.ProjectServices {
+		for _, srv := range services {
+			if srv.Node == nodeID && !isEnded(srv) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// cluster returns the view of the cluster used for scheduling.
+func (o *Orchestrator) cluster() scheduler.Cluster {
+	nodes := []node.Node{}
+	for _, nd := range o.Nodes {
+		nodes = append(nodes, nd)
+	}
+
+	return scheduler.Cluster{
+		Nodes:    nodes,
+		Services: o.GetServices(),
+	}
+}
+
+// SetDraining marks a node as draining, stopping new services from being
+// scheduled on it, or returns it to service.
+func (o *Orchestrator) SetDraining(id string, draining bool) error {
+	defer o.lock()()
+
+	nd, ok := o.Nodes[id]
+	if !ok {
+		return ErrNodeNotFound
+	}
+
+	log.WithFields(log.Fields{
+		"node":     id,
+		"draining": draining,
+	}).Info("Setting node draining")
+	nd.Draining = draining
+	o.Nodes[id] = nd
+
+	return nil
+}
+
+// removeLost drops services on lost nodes from state without contacting the
+// node, so that replacements are scheduled on healthy nodes.
+func (o *Orchestrator) removeLost() {
+	for project, services := range o.ProjectServices {
+		kept := []state.ServiceState{}
+		for _, srv := range services {
+			if srv.Status != status.LOST {
+				kept = append(kept, srv)
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"id":   srv.ID,
+				"name": srv.Name,
+				"node": srv.Node,
+			}).Info("Service lost with its node, rescheduling")
+			o.LostServices[srv.Node] = append(o.LostServices[srv.Node], srv)
+		}
+
+		o.ProjectServices[project] = kept
+	}
+}
+
+// cleanupLost makes a best-effort attempt to destroy services that were lost
+// on nodes that have since come back. Services on nodes that were removed
+// altogether are forgotten.
+func (o *Orchestrator) cleanupLost(ctx context.Context) {
+	plan := newUpdatePlan()
+	o.withLock(func() {
+		for nodeID, services := range o.LostServices {
+			nd, ok := o.Nodes[nodeID]
+			if !ok {
+				delete(o.LostServices, nodeID)
+				continue
+			}
+			if !nd.Healthy {
+				continue
+			}
+
+			for _, srv := range services {
+				log.WithFields(log.Fields{
+					"id":   srv.ID,
+					"name": srv.Name,
+					"node": nodeID,
+				}).Info("Cleaning up lost service")
+				o.destroyService(srv.Service.SrvName, srv, false, plan)
+			}
+
+			delete(o.LostServices, nodeID)
+		}
+	})
+
+	plan.execute(ctx)
+}
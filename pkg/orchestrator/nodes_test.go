@@ -0,0 +1,72 @@
+package orchestrator
+
+import (
+	"metis/pkg/node"
+	"metis/pkg/state"
+	"metis/pkg/status"
+	"testing"
+	"time"
+)
+
+func TestCheckHeartbeats(t *testing.T) {
+	tests := []struct {
+		name          string
+		lastHeartbeat time.Duration
+		services      []state.ServiceState
+		wantHealthy   bool
+		wantRemoved   bool
+	}{
+		{
+			name:          "recent heartbeat",
+			lastHeartbeat: time.Second,
+			wantHealthy:   true,
+		},
+		{
+			name:          "missed heartbeat",
+			lastHeartbeat: time.Hour,
+			services:      []state.ServiceState{{ID: "a", Status: status.RUNNING, Node: "node-a"}},
+		},
+		{
+			name:          "gone without services",
+			lastHeartbeat: 30 * 24 * time.Hour,
+			wantRemoved:   true,
+		},
+		{
+			name:          "gone with services",
+			lastHeartbeat: 30 * 24 * time.Hour,
+			services:      []state.ServiceState{{ID: "a", Status: status.RUNNING, Node: "node-a"}},
+		},
+		{
+			name:          "gone with ended services",
+			lastHeartbeat: 30 * 24 * time.Hour,
+			services: []state.ServiceState{
+				{ID: "a", Status: status.COMPLETED, Node: "node-a"},
+				{ID: "b", Status: status.FAILED, Node: "node-a"},
+			},
+			wantRemoved: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := NewOrchestrator()
+			o.Nodes["node-a"] = node.Node{
+				ID:            "node-a",
+				Registered:    true,
+				Healthy:       true,
+				LastHeartbeat: time.Now().Add(-test.lastHeartbeat),
+			}
+			o.ProjectServices["web"] = test.services
+
+			o.checkHeartbeats()
+
+			nd, ok := o.Nodes["node-a"]
+			if ok == test.wantRemoved {
+				t.Fatalf("node removed: %t, want %t", !ok, test.wantRemoved)
+			}
+			if ok && nd.Healthy != test.wantHealthy {
+				t.Errorf("node healthy: %t, want %t", nd.Healthy, test.wantHealthy)
+			}
+		})
+	}
+}
@@ -4,49 +4,126 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"metis/pkg/node"
 	"metis/pkg/project"
+	"metis/pkg/scheduler"
+	"metis/pkg/secret"
 	"metis/pkg/service"
 	"metis/pkg/state"
 	"metis/pkg/status"
-	"metis/pkg/traefik"
-	"net/http"
 	"os"
+	"reflect"
+	"time"
 
 	"github.com/Strum355/log"
 	"github.com/spf13/viper"
 )
 
 var (
-	ROUNDROBIN = 0
+	ErrProjectNotFound  = errors.New("project not found")
+	ErrProjectExists    = errors.New("project already exists")
+	ErrRevisionNotFound = errors.New("revision not found")
 )
 
 type Orchestrator struct {
 	Projects        []project.Project
 	ProjectServices map[string][]state.ServiceState
 	Nodes           map[string]node.Node
+
+	// LostServices holds services dropped from failed nodes, keyed by node,
+	// so they can be cleaned up if the node comes back.
+	LostServices map[string][]state.ServiceState
+
+	// Revisions holds the most recent configurations of each project, oldest
+	// first, keyed by project.
+	Revisions map[string][]project.Revision
+
+	// Deployments holds the canary and blue/green deployments in progress,
+	// keyed by project.
+	Deployments map[string]project.Deployment
+
+	// Restarts tracks the failures of each project's services, keyed by
+	// project.
+	Restarts map[string]RestartState
+
+	// Unschedulable holds the reason a project's services could not be
+	// placed during the last update, keyed by project.
+	Unschedulable map[string]string `json:"-"`
+
+	// CreateErrors holds the error that stopped a project's services from
+	// being created during the last update, keyed by project.
+	CreateErrors map[string]string `json:"-"`
+
+	// Orphans holds the containers found that are not part of the state,
+	// keyed by container ID.
+	Orphans map[string]Orphan `json:"-"`
+
+	secrets *secret.Store
+	routes  *routeCache
+	guard   *stateGuard
+
+	// adopting holds the nodes whose services are adopted by the next update
+	adopting map[string]bool
+
+	lastOrphanCheck time.Time
 }
 
 func NewOrchestrator() Orchestrator {
-	return Orchestrator{
+	o := Orchestrator{
 		Projects:        make([]project.Project, 0),
 		ProjectServices: make(map[string][]state.ServiceState),
 		Nodes:           make(map[string]node.Node),
+		LostServices:    make(map[string][]state.ServiceState),
+		Revisions:       make(map[string][]project.Revision),
+		Deployments:     make(map[string]project.Deployment),
+		Restarts:        make(map[string]RestartState),
+		Unschedulable:   make(map[string]string),
+		CreateErrors:    make(map[string]string),
+		Orphans:         make(map[string]Orphan),
+		routes:          newRouteCache(),
+		guard:           &stateGuard{},
+		adopting:        make(map[string]bool),
 	}
+	o.publish()
+	return o
 }
 
-func OrchestratorFromState(state []byte) (Orchestrator, error) {
+func OrchestratorFromState(byts []byte) (Orchestrator, error) {
 	var o Orchestrator
-	err := json.Unmarshal(state, &o)
+	err := json.Unmarshal(byts, &o)
 	if err != nil {
 		return o, err
 	}
+	if o.LostServices == nil {
+		o.LostServices = make(map[string][]state.ServiceState)
+	}
+	if o.Revisions == nil {
+		o.Revisions = make(map[string][]project.Revision)
+	}
+	if o.Deployments == nil {
+		o.Deployments = make(map[string]project.Deployment)
+	}
+	if o.Restarts == nil {
+		o.Restarts = make(map[string]RestartState)
+	}
+	o.Unschedulable = make(map[string]string)
+	o.CreateErrors = make(map[string]string)
+	o.Orphans = make(map[string]Orphan)
+	o.routes = newRouteCache()
+	o.guard = &stateGuard{}
+	o.adopting = make(map[string]bool)
+	o.publish()
 	return o, nil
 }
 
 func (o *Orchestrator) WriteState() error {
+	defer o.lock()()
+
+	return o.writeState()
+}
+
+func (o *Orchestrator) writeState() error {
 	err := os.MkdirAll(viper.GetString("metis.home"), os.ModePerm)
 	if err != nil {
 		return err
@@ -65,47 +142,43 @@ func (o *Orchestrator) WriteState() error {
 	return ioutil.WriteFile(writeLocation, marsh, 0644)
 }
 
+// NodeHealthcheck checks the health of every node that is not kept alive by
+// heartbeats.
 func (o *Orchestrator) NodeHealthcheck() {
-	for i := range o.Nodes {
-		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/", o.Nodes[i].Address, o.Nodes[i].APIPort), nil)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"node":    o.Nodes[i].ID,
-				"address": o.Nodes[i].APIPort,
-				"error":   err.Error(),
-			}).Info("Node not healthy")
-			continue
+	o.nodeHealthcheck(context.Background())
+}
+
+func (o *Orchestrator) nodeHealthcheck(ctx context.Context) {
+	nodes := []node.Node{}
+	o.withLock(func() {
+		for _, nd := range o.Nodes {
+			if !nd.Registered {
+				nodes = append(nodes, nd)
+			}
 		}
-		req.Header.Set("Token", viper.GetString("metis.secret"))
-		client := http.Client{}
-		response, err := client.Do(req)
-		if err != nil {
-			nd := o.Nodes[i]
-			nd.Healthy = false
-			o.Nodes[i] = nd
-			log.WithFields(log.Fields{
-				"node":    o.Nodes[i].ID,
-				"address": o.Nodes[i].APIPort,
-				"error":   err.Error(),
-			}).Info("Node not healthy")
+	})
+
+	// Nodes are checked concurrently, then updated once every check is done
+	errs := make([]error, len(nodes))
+	forEach(len(nodes), func(i int) {
+		errs[i] = nodes[i].Ping(ctx)
+	})
+
+	defer o.lock()()
+	for i, checked := range nodes {
+		nd, ok := o.Nodes[checked.ID]
+		if !ok || nd.Registered {
 			continue
 		}
-
-		if response.StatusCode != 200 {
-			nd := o.Nodes[i]
-			nd.Healthy = false
-			o.Nodes[i] = nd
+		if errs[i] != nil {
 			log.WithFields(log.Fields{
-				"node":          o.Nodes[i].ID,
-				"address":       o.Nodes[i].APIPort,
-				"response_code": response.StatusCode,
+				"node":    nd.ID,
+				"address": nd.Address,
+				"error":   errs[i].Error(),
 			}).Info("Node not healthy")
-			continue
 		}
-
-		nd := o.Nodes[i]
-		nd.Healthy = true
-		o.Nodes[i] = nd
+		nd.SetHealthy(errs[i] == nil)
+		o.Nodes[nd.ID] = nd
 	}
 }
 
@@ -114,26 +187,110 @@ func (o *Orchestrator) GetProjects() ([]project.Project, error) {
 }
 
 func (o *Orchestrator) CreateProject(proj project.Project) error {
+	defer o.lock()()
+
+	if _, err := o.GetProject(proj.Name); err == nil {
+		return ErrProjectExists
+	}
+
 	log.WithFields(log.Fields{
 		"name": proj.Name,
 	}).Info("Creating project")
+	proj.Revision = 1
 	o.ProjectServices[proj.Name] = make([]state.ServiceState, 0)
 
 	o.Projects = append(o.Projects, proj)
+	o.recordRevision(proj, "created")
 
 	return nil
 }
 
+// UpdateProject replaces the configuration of an existing project. A changed
+// configuration is given a new revision, which running services are rolled
+// over to by the following updates.
+func (o *Orchestrator) UpdateProject(proj project.Project) error {
+	defer o.lock()()
+
+	return o.updateProject(proj, "updated", false)
+}
+
+// updateProject replaces the configuration of a project, giving it a new
+// revision if the configuration changed or if forced to.
+func (o *Orchestrator) updateProject(proj project.Project, reason string, force bool) error {
+	for i := range o.Projects {
+		if o.Projects[i].Name != proj.Name {
+			continue
+		}
+
+		previous := o.Projects[i]
+		proj.Revision = previous.Revision
+		if !force && reflect.DeepEqual(proj.Configuration, previous.Configuration) {
+			o.Projects[i] = proj
+			return nil
+		}
+		proj.Revision = o.nextRevision(previous)
+
+		log.WithFields(log.Fields{
+			"name":     proj.Name,
+			"revision": proj.Revision,
+			"reason":   reason,
+		}).Info("Updating project")
+		o.Projects[i] = proj
+		o.recordRevision(proj, reason)
+		o.startDeployment(previous, proj)
+		// The new revision may have fixed whatever was failing
+		delete(o.Restarts, proj.Name)
+
+		return nil
+	}
+
+	return ErrProjectNotFound
+}
+
+// DestroyProject removes a project and all of its services. Services that
+// cannot be destroyed are logged and dropped from state regardless. The
+// project is dropped from state first, and its services destroyed after.
 func (o *Orchestrator) DestroyProject(proj project.Project) error {
+	plan := newUpdatePlan()
+	err := o.destroyProject(proj, plan)
+	if err != nil {
+		return err
+	}
+
+	plan.execute(context.Background())
+
+	return nil
+}
+
+func (o *Orchestrator) destroyProject(proj project.Project, plan *updatePlan) error {
+	defer o.lock()()
+
+	if _, err := o.GetProject(proj.Name); err != nil {
+		return err
+	}
+
 	log.WithFields(log.Fields{
 		"name": proj.Name,
 	}).Info("Destroying project")
 	for _, srv := range o.ProjectServices[proj.Name] {
-		_, err := o.destroyService(srv)
-		if err != nil {
-			return err
+		if !isEnded(srv) {
+			o.destroyService(proj.Name, srv, false, plan)
+		}
+	}
+
+	projects := []project.Project{}
+	for _, p := range o.Projects {
+		if p.Name != proj.Name {
+			projects = append(projects, p)
 		}
 	}
+	o.Projects = projects
+	delete(o.ProjectServices, proj.Name)
+	delete(o.Revisions, proj.Name)
+	delete(o.Deployments, proj.Name)
+	delete(o.Restarts, proj.Name)
+	delete(o.Unschedulable, proj.Name)
+	delete(o.CreateErrors, proj.Name)
 
 	return nil
 }
@@ -146,28 +303,34 @@ func (o *Orchestrator) GetServices() []state.ServiceState {
 	return states
 }
 
-func (o *Orchestrator) createService(srv service.Service) (state.ServiceState, error) {
-	ctx := context.Background()
-	log.WithFields(log.Fields{
-		"name": srv.Name(),
-	}).Info("Creating service")
+// placeService picks the node to create a service of a project on, counting
+// the services already planned, and plans its creation with its secrets.
+func (o *Orchestrator) placeService(proj project.Project, srv service.Service, plan *updatePlan) error {
+	sched, err := scheduler.ForProject(proj)
+	if err != nil {
+		return err
+	}
 
-	nodeIndex := ROUNDROBIN + 1
-	if nodeIndex >= len(o.Nodes) {
-		nodeIndex = 0
+	srv, err = o.withSecrets(srv)
+	if err != nil {
+		return err
 	}
-	ROUNDROBIN = nodeIndex
 
-	return o.Nodes[fmt.Sprintf("node-%d", nodeIndex)].CreateService(ctx, srv)
-}
+	cluster := o.cluster()
+	cluster.Services = append(cluster.Services, plan.placed()...)
+	nd, err := sched.Schedule(proj, cluster)
+	if err != nil {
+		return err
+	}
 
-func (o *Orchestrator) destroyService(srv state.ServiceState) (state.ServiceState, error) {
-	ctx := context.Background()
-	log.WithFields(log.Fields{
-		"name": srv.Service.Name(),
-	}).Info("Destroying service")
+	plan.create(proj.Name, nd, srv.(service.DockerService))
 
-	return o.Nodes[srv.Node].DestroyService(ctx, srv)
+	return nil
+}
+
+// destroyService plans a service of a project to be destroyed on its node.
+func (o *Orchestrator) destroyService(projectName string, srv state.ServiceState, remove bool, plan *updatePlan) {
+	plan.destroy(projectName, o.Nodes[srv.Node], srv, remove)
 }
 
 func (o *Orchestrator) GetProject(name string) (project.Project, error) {
@@ -177,70 +340,215 @@ func (o *Orchestrator) GetProject(name string) (project.Project, error) {
 		}
 	}
 
-	return project.Project{}, errors.New("project not found")
+	return project.Project{}, ErrProjectNotFound
 }
 
+// Update reconciles the cluster with the state. Updates do not overlap, and
+// hold the state lock only while reading or changing the state, so that API
+// handlers are not held up by agents.
 func (o *Orchestrator) Update() error {
+	o.guard.update.Lock()
+	defer o.guard.update.Unlock()
+
 	ctx := context.Background()
-	for k := range o.ProjectServices {
-		for y := range o.ProjectServices[k] {
-			srv, err := o.Nodes[o.ProjectServices[k][y].Node].ServiceHealth(ctx, o.ProjectServices[k][y])
+	o.nodeHealthcheck(ctx)
+	o.withLock(o.checkHeartbeats)
+	o.adoptPending(ctx)
+
+	o.cleanupLost(ctx)
+
+	o.updateServiceHealth(ctx)
+
+	o.reconcile(ctx)
+
+	o.collectOrphans(ctx)
+
+	err := o.refreshRoutes()
+	if err != nil {
+		return err
+	}
+
+	err = o.WriteState()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// reconcile plans the services to create and destroy for every project, then
+// carries out the plan and applies its results.
+func (o *Orchestrator) reconcile(ctx context.Context) {
+	plan := newUpdatePlan()
+	o.withLock(func() {
+		o.removeLost()
+
+		for project := range o.ProjectServices {
+			proj, err := o.GetProject(project)
 			if err != nil {
-				log.WithError(err).Error("Could not update status of service")
-				srv.Status = status.UNHEALTHY
-				o.ProjectServices[k][y] = srv
+				log.WithFields(log.Fields{
+					"name": project,
+				}).Error("Services found for unknown project")
 				continue
 			}
-			o.ProjectServices[k][y] = srv
+
+			o.handleEnded(proj, plan)
+			o.reconcileProject(proj, plan)
 		}
-	}
 
-	for project, services := range o.ProjectServices {
-		healthy := 0
-		for _, service := range services {
-			if service.Status == status.RUNNING || service.Status == status.CREATED {
-				healthy++
+		o.removeStopped(plan)
+		o.stopUnhealthy(plan)
+	})
+
+	plan.execute(ctx)
+
+	var leftover *updatePlan
+	o.withLock(func() {
+		leftover = o.applyPlan(plan)
+	})
+	leftover.execute(ctx)
+}
+
+// serviceRef locates a service in ProjectServices.
+type serviceRef struct {
+	project string
+	id      string
+}
+
+// updateServiceHealth updates the status of every service. Services on lost
+// nodes are marked lost, and the rest are checked with one request per
+// healthy node, sent concurrently.
+func (o *Orchestrator) updateServiceHealth(ctx context.Context) {
+	nodes := []node.Node{}
+	refs := map[string][]serviceRef{}
+	services := map[string][]state.ServiceState{}
+	o.withLock(func() {
+		gracePeriod := viper.GetDuration("metis.node.grace_period")
+		for k := range o.ProjectServices {
+			for y, srv := range o.ProjectServices[k] {
+				if isEnded(srv) {
+					continue
+				}
+
+				nd, ok := o.Nodes[srv.Node]
+				if !ok || nd.Lost(gracePeriod) {
+					o.ProjectServices[k][y].Status = status.LOST
+					continue
+				}
+				if !nd.Healthy {
+					// Keep the last known status until the grace period is up
+					continue
+				}
+
+				if _, ok := refs[nd.ID]; !ok {
+					nodes = append(nodes, nd)
+				}
+				refs[nd.ID] = append(refs[nd.ID], serviceRef{project: k, id: srv.ID})
+				services[nd.ID] = append(services[nd.ID], srv)
 			}
 		}
+	})
 
-		proj, err := o.GetProject(project)
-		if err != nil {
-			panic(err)
+	type result struct {
+		states []state.ServiceState
+		errs   map[string]error
+		err    error
+	}
+	results := make([]result, len(nodes))
+	forEach(len(nodes), func(i int) {
+		states, errs, err := nodes[i].ServicesHealth(ctx, services[nodes[i].ID])
+		results[i] = result{states: states, errs: errs, err: err}
+	})
+
+	defer o.lock()()
+	for i, nd := range nodes {
+		if results[i].err != nil {
+			// The node answers pings, so keep the last known status rather
+			// than failing every service on it over one request
+			log.WithError(results[i].err).WithFields(log.Fields{
+				"node": nd.ID,
+			}).Error("Could not update status of services")
+			continue
 		}
 
-		if healthy < proj.Configuration.Count {
-			state, err := o.createService(service.DockerService{
-				SrvName:       proj.Name,
-				DockerImage:   proj.Configuration.ImageName,
-				DesiredStatus: status.RUNNING,
-				ContainerPort: proj.Configuration.ContainerPort,
-			})
-			if err != nil {
-				return err
+		for j, ref := range refs[nd.ID] {
+			srv := results[i].states[j]
+			if err := results[i].errs[srv.ID]; err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"id": srv.ID,
+				}).Error("Could not update status of service")
+				srv.Status = status.UNHEALTHY
+			} else if srv.Status != status.RUNNING {
+				srv.RunningSince = time.Time{}
+			} else if srv.RunningSince.IsZero() {
+				srv.RunningSince = time.Now()
 			}
+			o.setService(ref, srv)
+		}
+	}
+}
 
-			o.ProjectServices[proj.Name] = append(o.ProjectServices[proj.Name], state)
+// setService replaces a service in ProjectServices, unless it has been
+// removed since it was read.
+func (o *Orchestrator) setService(ref serviceRef, srv state.ServiceState) {
+	for i := range o.ProjectServices[ref.project] {
+		if o.ProjectServices[ref.project][i].ID == ref.id {
+			o.ProjectServices[ref.project][i] = srv
+			return
 		}
 	}
+}
 
-	err := o.removeStopped()
-	if err != nil {
-		return nil
+// serviceFromProject builds the service every instance of the project should
+// be running.
+func serviceFromProject(proj project.Project) service.DockerService {
+	return service.DockerService{
+		SrvName:       proj.Name,
+		DockerImage:   proj.Configuration.ImageName,
+		DesiredStatus: status.RUNNING,
+		ContainerPort: proj.Configuration.RoutedPorts()[0].ContainerPort,
+		Ports:         servicePorts(proj),
+		Resources:     proj.Configuration.Resources,
+		Revision:      proj.Revision,
+
+		Env:        proj.Configuration.Env,
+		Entrypoint: proj.Configuration.Entrypoint,
+		Command:    proj.Configuration.Command,
+		Args:       proj.Configuration.Args,
+		WorkingDir: proj.Configuration.WorkingDir,
+		User:       proj.Configuration.User,
+		Mounts:     proj.Configuration.Mounts,
+		Secrets:    proj.Configuration.Secrets,
+
+		LivenessProbe:  proj.Configuration.LivenessProbe,
+		ReadinessProbe: proj.Configuration.ReadinessProbe,
 	}
-	err = o.stopUnhealthy()
-	if err != nil {
+}
+
+// servicePorts returns the ports a project's services publish, or nil for
+// projects with a single unnamed port.
+func servicePorts(proj project.Project) []service.Port {
+	if len(proj.Configuration.Ports) == 0 {
 		return nil
 	}
 
-	err = o.WriteState()
-	if err != nil {
-		return err
+	ports := []service.Port{}
+	for _, port := range proj.Configuration.Ports {
+		protocol := service.TCP
+		if port.Protocol == service.UDP {
+			protocol = service.UDP
+		}
+		ports = append(ports, service.Port{
+			Name:          port.Name,
+			ContainerPort: port.ContainerPort,
+			Protocol:      protocol,
+		})
 	}
 
-	return nil
+	return ports
 }
 
-func (o *Orchestrator) removeStopped() error {
+func (o *Orchestrator) removeStopped(plan *updatePlan) {
 	for project, services := range o.ProjectServices {
 		kept := []state.ServiceState{}
 		for _, service := range services {
@@ -249,7 +557,7 @@ func (o *Orchestrator) removeStopped() error {
 				continue
 			}
 
-			_, _ = o.destroyService(service)
+			o.destroyService(project, service, false, plan)
 
 			log.WithFields(log.Fields{
 				"id":   service.ID,
@@ -259,23 +567,21 @@ func (o *Orchestrator) removeStopped() error {
 
 		o.ProjectServices[project] = kept
 	}
-
-	return nil
 }
 
-func (o *Orchestrator) stopUnhealthy() error {
+// stopUnhealthy removes unhealthy and exited services so they are replaced.
+// Services that cannot be destroyed are dropped from state regardless, so
+// that one unreachable node cannot block replacements.
+func (o *Orchestrator) stopUnhealthy(plan *updatePlan) {
 	for project, services := range o.ProjectServices {
 		kept := []state.ServiceState{}
 		for _, service := range services {
-			if service.Status == status.UNHEALTHY {
+			if service.Status == status.UNHEALTHY || service.Status == status.EXITED {
 				log.WithFields(log.Fields{
 					"id":   service.ID,
 					"name": service.Name,
 				}).Info("Service unhealthy, removing service")
-				_, err := o.destroyService(service)
-				if err != nil {
-					return err
-				}
+				o.destroyService(project, service, false, plan)
 				continue
 			}
 			kept = append(kept, service)
@@ -283,8 +589,6 @@ func (o *Orchestrator) stopUnhealthy() error {
 
 		o.ProjectServices[project] = kept
 	}
-
-	return nil
 }
 
 func (o *Orchestrator) CountHealthy(project string) (int, error) {
@@ -301,42 +605,3 @@ func (o *Orchestrator) CountHealthy(project string) (int, error) {
 
 	return healthy, nil
 }
-
-func (o *Orchestrator) GetTraefikConfig() traefik.Configuration {
-	ctx := context.Background()
-	config := traefik.Configuration{HTTP: traefik.HttpConfig{}}
-	config.HTTP.Routers = map[string]traefik.Router{}
-	config.HTTP.Services = map[string]traefik.Service{}
-
-	for _, project := range o.Projects {
-		services := o.ProjectServices[project.Name]
-		router := traefik.Router{
-			Rule:    fmt.Sprintf("Host(`%s`)", project.Configuration.Host),
-			Service: project.Name,
-		}
-		config.HTTP.Routers[project.Name+"-router"] = router
-
-		urls := []traefik.URL{}
-		for _, service := range services {
-			service, err := o.Nodes[service.Node].ServiceHealth(ctx, service)
-			if err != nil {
-				log.WithError(err).Error("Could not fetch status for service")
-				continue
-			}
-			if service.Status != status.RUNNING {
-				continue
-			}
-			url := o.Nodes[service.Node].Address
-			urls = append(urls, traefik.URL{URL: fmt.Sprintf("http://%s:%d", url, service.ExposedPort)})
-		}
-
-		service := traefik.Service{
-			LoadBalancer: traefik.LoadBalancer{
-				Servers: urls,
-			},
-		}
-		config.HTTP.Services[project.Name] = service
-	}
-
-	return config
-}
@@ -0,0 +1,186 @@
+package orchestrator
+
+import (
+	"context"
+	"metis/pkg/node"
+	"metis/pkg/service"
+	"metis/pkg/state"
+	"sort"
+	"time"
+
+	"github.com/Strum355/log"
+	"github.com/spf13/viper"
+)
+
+// Orphan is a container this controller created that is not part of its
+// state, such as one created just before the controller crashed, or one
+// whose removal failed.
+type Orphan struct {
+	Node     string `json:"node"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Project  string `json:"project"`
+	Revision int    `json:"revision"`
+	State    string `json:"state"`
+	// FirstSeen is when the container was first found orphaned, and
+	// RemoveAt when it is removed unless metis.gc.dry_run is set.
+	FirstSeen time.Time `json:"first_seen"`
+	RemoveAt  time.Time `json:"remove_at"`
+}
+
+// OrphanReport returns the orphaned containers found by the last check,
+// ordered by when they will be removed.
+func (o *Orchestrator) OrphanReport() []Orphan {
+	orphans := []Orphan{}
+	for _, orphan := range o.Orphans {
+		orphans = append(orphans, orphan)
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].RemoveAt.Equal(orphans[j].RemoveAt) {
+			return orphans[i].ID < orphans[j].ID
+		}
+		return orphans[i].RemoveAt.Before(orphans[j].RemoveAt)
+	})
+
+	return orphans
+}
+
+// collectOrphans lists the containers on every healthy node every
+// metis.gc.interval, tracking those that are not part of the state and
+// removing them once they have been orphaned for metis.gc.grace_period.
+// Nodes waiting to have their services adopted are skipped. Containers are
+// listed and removed without the state lock.
+func (o *Orchestrator) collectOrphans(ctx context.Context) {
+	controllerID := viper.GetString("metis.controller.id")
+	nodes := []node.Node{}
+	o.withLock(func() {
+		if controllerID == "" || time.Since(o.lastOrphanCheck) < viper.GetDuration("metis.gc.interval") {
+			return
+		}
+		o.lastOrphanCheck = time.Now()
+
+		for id, nd := range o.Nodes {
+			if nd.Healthy && !o.adopting[id] {
+				nodes = append(nodes, nd)
+			}
+		}
+	})
+	if len(nodes) == 0 {
+		return
+	}
+
+	statuses := make([][]state.ContainerStatus, len(nodes))
+	errs := make([]error, len(nodes))
+	forEach(len(nodes), func(i int) {
+		statuses[i], errs[i] = nodes[i].ServiceStatuses(ctx)
+	})
+
+	remove := []Orphan{}
+	removeFrom := []node.Node{}
+	o.withLock(func() {
+		o.trackOrphans(nodes, statuses, errs, controllerID)
+		if viper.GetBool("metis.gc.dry_run") {
+			return
+		}
+
+		checked := map[string]bool{}
+		for i, nd := range nodes {
+			checked[nd.ID] = errs[i] == nil
+		}
+		for _, orphan := range o.Orphans {
+			if checked[orphan.Node] && !time.Now().Before(orphan.RemoveAt) {
+				remove = append(remove, orphan)
+				removeFrom = append(removeFrom, o.Nodes[orphan.Node])
+			}
+		}
+	})
+
+	removeErrs := make([]error, len(remove))
+	forEach(len(remove), func(i int) {
+		orphan := remove[i]
+		_, removeErrs[i] = removeFrom[i].DestroyService(ctx, state.ServiceState{
+			ID:      orphan.ID,
+			Name:    orphan.Name,
+			Service: service.DockerService{SrvName: orphan.Project},
+			Node:    orphan.Node,
+		})
+	})
+
+	defer o.lock()()
+	for i, orphan := range remove {
+		if removeErrs[i] != nil {
+			log.WithError(removeErrs[i]).WithFields(log.Fields{
+				"node": orphan.Node,
+				"id":   orphan.ID,
+			}).Error("Could not remove orphaned container")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"node":    orphan.Node,
+			"id":      orphan.ID,
+			"project": orphan.Project,
+		}).Info("Removed orphaned container")
+		delete(o.Orphans, orphan.ID)
+	}
+}
+
+// trackOrphans records the containers listed on each node that are not part
+// of the state as orphans, and forgets orphans that are gone or were
+// adopted. Orphans on nodes that could not be listed are kept until they can
+// be.
+func (o *Orchestrator) trackOrphans(nodes []node.Node, statuses [][]state.ContainerStatus, errs []error, controllerID string) {
+	known := o.knownServices()
+	for _, services := range o.LostServices {
+		for _, srv := range services {
+			known[srv.ID] = true
+		}
+	}
+
+	gracePeriod := viper.GetDuration("metis.gc.grace_period")
+	checked := map[string]bool{}
+	found := map[string]bool{}
+	for i, nd := range nodes {
+		if errs[i] != nil {
+			log.WithError(errs[i]).WithFields(log.Fields{
+				"node": nd.ID,
+			}).Error("Could not list containers to collect orphans")
+			continue
+		}
+		checked[nd.ID] = true
+
+		for _, cs := range statuses[i] {
+			if cs.Controller != controllerID || known[cs.ID] {
+				continue
+			}
+			found[cs.ID] = true
+
+			orphan, ok := o.Orphans[cs.ID]
+			if !ok {
+				orphan = Orphan{
+					Node:      nd.ID,
+					ID:        cs.ID,
+					Name:      cs.Name,
+					Project:   cs.Project,
+					Revision:  cs.Revision,
+					FirstSeen: time.Now(),
+					RemoveAt:  time.Now().Add(gracePeriod),
+				}
+				log.WithFields(log.Fields{
+					"node":      nd.ID,
+					"id":        cs.ID,
+					"project":   cs.Project,
+					"remove_at": orphan.RemoveAt,
+				}).Warn("Found orphaned container")
+			}
+			orphan.State = cs.State
+			o.Orphans[cs.ID] = orphan
+		}
+	}
+
+	for id, orphan := range o.Orphans {
+		if checked[orphan.Node] && !found[id] {
+			delete(o.Orphans, id)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package orchestrator
+
+import (
+	"context"
+	"metis/pkg/node"
+	"metis/pkg/service"
+	"metis/pkg/state"
+	"metis/pkg/status"
+
+	"github.com/Strum355/log"
+)
+
+// updatePlan holds the services to create and destroy, decided under the
+// state lock and carried out by execute once it is released, so that slow
+// agents do not hold up API handlers.
+type updatePlan struct {
+	creates  []plannedCreate
+	destroys []plannedDestroy
+	// destroying holds the IDs of the services planned to be destroyed, so
+	// that no service is destroyed twice
+	destroying map[string]bool
+}
+
+// plannedCreate is a service of a project placed on a node.
+type plannedCreate struct {
+	project string
+	node    node.Node
+	service service.DockerService
+
+	state state.ServiceState
+	err   error
+}
+
+// plannedDestroy is a service to destroy. Services to be removed are dropped
+// from state once they are destroyed, and kept to be retried otherwise. The
+// rest were dropped or ended when planned.
+type plannedDestroy struct {
+	project string
+	node    node.Node
+	srv     state.ServiceState
+	remove  bool
+
+	err error
+}
+
+func newUpdatePlan() *updatePlan {
+	return &updatePlan{destroying: make(map[string]bool)}
+}
+
+// create plans a service of a project to be created on a node.
+func (p *updatePlan) create(projectName string, nd node.Node, srv service.DockerService) {
+	p.creates = append(p.creates, plannedCreate{project: projectName, node: nd, service: srv})
+}
+
+// destroy plans a service of a project to be destroyed on a node.
+func (p *updatePlan) destroy(projectName string, nd node.Node, srv state.ServiceState, remove bool) {
+	if p.destroying[srv.ID] {
+		return
+	}
+	p.destroying[srv.ID] = true
+	p.destroys = append(p.destroys, plannedDestroy{project: projectName, node: nd, srv: srv, remove: remove})
+}
+
+// placed returns the services planned to be created, so that they count
+// towards the load of their nodes when scheduling the next.
+func (p *updatePlan) placed() []state.ServiceState {
+	placed := []state.ServiceState{}
+	for _, create := range p.creates {
+		placed = append(placed, state.ServiceState{
+			Status:  status.CREATED,
+			Service: create.service,
+			Node:    create.node.ID,
+		})
+	}
+
+	return placed
+}
+
+// execute creates and destroys the planned services concurrently, recording
+// the result of each. It must be called without the state lock.
+func (p *updatePlan) execute(ctx context.Context) {
+	forEach(len(p.creates)+len(p.destroys), func(i int) {
+		if i < len(p.creates) {
+			create := &p.creates[i]
+			log.WithFields(log.Fields{
+				"name": create.service.Name(),
+				"node": create.node.ID,
+			}).Info("Creating service")
+			create.state, create.err = create.node.CreateService(ctx, create.service)
+			return
+		}
+
+		destroy := &p.destroys[i-len(p.creates)]
+		log.WithFields(log.Fields{
+			"id":   destroy.srv.ID,
+			"name": destroy.srv.Service.Name(),
+			"node": destroy.node.ID,
+		}).Info("Destroying service")
+		_, destroy.err = destroy.node.DestroyService(ctx, destroy.srv)
+		if destroy.err != nil {
+			log.WithError(destroy.err).WithFields(log.Fields{
+				"id":   destroy.srv.ID,
+				"name": destroy.srv.Service.Name(),
+			}).Error("Could not destroy service")
+		}
+	})
+}
+
+// applyPlan adds the services that were created to their projects and drops
+// the services that were removed. Services created for projects destroyed in
+// the meantime are returned in a plan to destroy them.
+func (o *Orchestrator) applyPlan(plan *updatePlan) *updatePlan {
+	leftover := newUpdatePlan()
+	for _, create := range plan.creates {
+		if _, err := o.GetProject(create.project); err != nil {
+			if create.err == nil {
+				leftover.destroy(create.project, create.node, create.state, false)
+			}
+			continue
+		}
+		if create.err != nil {
+			log.WithError(create.err).WithFields(log.Fields{
+				"name": create.project,
+				"node": create.node.ID,
+			}).Error("Could not create service")
+			o.CreateErrors[create.project] = create.err.Error()
+			continue
+		}
+
+		o.ProjectServices[create.project] = append(o.ProjectServices[create.project], create.state)
+	}
+
+	for _, destroy := range plan.destroys {
+		if !destroy.remove || destroy.err != nil {
+			continue
+		}
+
+		services, ok := o.ProjectServices[destroy.project]
+		if !ok {
+			continue
+		}
+		kept := []state.ServiceState{}
+		for _, srv := range services {
+			if srv.ID != destroy.srv.ID {
+				kept = append(kept, srv)
+			}
+		}
+		o.ProjectServices[destroy.project] = kept
+	}
+
+	return leftover
+}
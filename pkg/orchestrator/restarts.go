@@ -0,0 +1,103 @@
+package orchestrator
+
+import (
+	"metis/pkg/project"
+	"metis/pkg/status"
+	"time"
+
+	"github.com/Strum355/log"
+	"github.com/spf13/viper"
+)
+
+// RestartState tracks the failures of a project's services, to back off
+// between replacements and detect crash loops.
+type RestartState struct {
+	// Failures is how many services were replaced since the failure count
+	// was last reset
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// handleEnded applies the restart policy of a project to services that have
+// exited or become unhealthy. Services to be restarted are left to be
+// replaced, after a backoff. Other services have their containers destroyed
+// and are kept as completed or failed, so that they are not replaced.
+// Draining services are being removed whichever way they end.
+func (o *Orchestrator) handleEnded(proj project.Project, plan *updatePlan) {
+	policy := proj.Configuration.RestartPolicy
+	restarts := o.Restarts[proj.Name]
+	if restarts.Failures > 0 && time.Since(restarts.LastFailure) > viper.GetDuration("metis.restart.reset_after") {
+		restarts = RestartState{}
+	}
+
+	services := o.ProjectServices[proj.Name]
+	for i, srv := range services {
+		if srv.Draining || (srv.Status != status.EXITED && srv.Status != status.UNHEALTHY) {
+			continue
+		}
+
+		clean := srv.Status == status.EXITED && srv.ExitCode == 0
+		ended := ""
+		switch {
+		case policy.Policy == project.NEVER && clean:
+			ended = status.COMPLETED
+		case policy.Policy == project.NEVER:
+			ended = status.FAILED
+		case policy.Policy == project.ONFAILURE && clean:
+			ended = status.COMPLETED
+		case policy.Policy == project.ONFAILURE && policy.MaxRetries > 0 && restarts.Failures >= policy.MaxRetries:
+			ended = status.FAILED
+		}
+
+		if ended == "" {
+			restarts.Failures++
+			restarts.LastFailure = time.Now()
+			restarts.NextAttempt = restarts.LastFailure.Add(policy.Backoff(restarts.Failures))
+			log.WithFields(log.Fields{
+				"id":        srv.ID,
+				"name":      srv.Name,
+				"exit_code": srv.ExitCode,
+				"failures":  restarts.Failures,
+				"backoff":   restarts.NextAttempt.Sub(restarts.LastFailure).String(),
+			}).Info("Service ended, restarting after backoff")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"id":        srv.ID,
+			"name":      srv.Name,
+			"exit_code": srv.ExitCode,
+			"status":    ended,
+		}).Info("Service ended, not restarting")
+		o.destroyService(proj.Name, srv, false, plan)
+		services[i].Status = status.ServiceStatus(ended)
+		services[i].Ready = false
+	}
+
+	o.Restarts[proj.Name] = restarts
+}
+
+// backingOff reports whether replacements for a project's failed services
+// must wait.
+func (o *Orchestrator) backingOff(name string) bool {
+	return time.Now().Before(o.Restarts[name].NextAttempt)
+}
+
+// ProjectStatus returns CRASHLOOP if a project's services keep failing, or an
+// empty status otherwise.
+func (o *Orchestrator) ProjectStatus(name string) status.ServiceStatus {
+	proj, err := o.GetProject(name)
+	if err != nil {
+		return ""
+	}
+
+	restarts := o.Restarts[name]
+	policy := proj.Configuration.RestartPolicy
+	if restarts.Failures >= viper.GetInt("metis.restart.crashloop_threshold") ||
+		(policy.Policy == project.ONFAILURE && policy.MaxRetries > 0 && restarts.Failures >= policy.MaxRetries) {
+		return status.CRASHLOOP
+	}
+
+	return ""
+}
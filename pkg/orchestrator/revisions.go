@@ -0,0 +1,73 @@
+package orchestrator
+
+import (
+	"fmt"
+	"metis/pkg/project"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// GetRevisions returns the recorded revisions of a project, oldest first.
+func (o *Orchestrator) GetRevisions(name string) ([]project.Revision, error) {
+	if _, err := o.GetProject(name); err != nil {
+		return nil, err
+	}
+
+	return o.Revisions[name], nil
+}
+
+// Rollback returns a project to the configuration of a previous revision.
+// The rollback is recorded as a new revision and rolled out like any other
+// update.
+func (o *Orchestrator) Rollback(name string, revision int) error {
+	defer o.lock()()
+
+	proj, err := o.GetProject(name)
+	if err != nil {
+		return err
+	}
+
+	for _, rev := range o.Revisions[name] {
+		if rev.Revision != revision {
+			continue
+		}
+
+		proj.Configuration = rev.Configuration
+		return o.updateProject(proj, fmt.Sprintf("rollback to revision %d", revision), false)
+	}
+
+	return ErrRevisionNotFound
+}
+
+// recordRevision adds the current configuration of a project to its history,
+// dropping the oldest revisions past metis.history.limit.
+func (o *Orchestrator) recordRevision(proj project.Project, reason string) {
+	revisions := append(o.Revisions[proj.Name], project.Revision{
+		Revision:      proj.Revision,
+		Configuration: proj.Configuration,
+		CreatedAt:     time.Now(),
+		Reason:        reason,
+	})
+
+	limit := viper.GetInt("metis.history.limit")
+	if limit > 0 && len(revisions) > limit {
+		revisions = revisions[len(revisions)-limit:]
+	}
+
+	o.Revisions[proj.Name] = revisions
+}
+
+// nextRevision returns the revision number for the next configuration of a
+// project. Revisions only ever increase, even when an aborted deployment has
+// returned the project to an earlier revision.
+func (o *Orchestrator) nextRevision(proj project.Project) int {
+	next := proj.Revision + 1
+	for _, rev := range o.Revisions[proj.Name] {
+		if rev.Revision >= next {
+			next = rev.Revision + 1
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,263 @@
+package orchestrator
+
+import (
+	"errors"
+	"metis/pkg/project"
+	"metis/pkg/scheduler"
+	"metis/pkg/state"
+	"metis/pkg/status"
+	"time"
+
+	"github.com/Strum355/log"
+)
+
+// reconcileProject moves the services of a project towards its configuration.
+// Services of older revisions are replaced by rolling over to the current
+// revision, keeping within the surge and unavailable limits of the project's
+// update strategy.
+func (o *Orchestrator) reconcileProject(proj project.Project, plan *updatePlan) {
+	if deployment, ok := o.Deployments[proj.Name]; ok {
+		o.reconcileDeployment(proj, deployment, plan)
+		return
+	}
+
+	toCreate, remove := planRollout(proj, o.ProjectServices[proj.Name])
+
+	delete(o.Unschedulable, proj.Name)
+	delete(o.CreateErrors, proj.Name)
+	o.scheduleServices(proj, toCreate, plan)
+	o.removeServices(proj.Name, remove, plan)
+}
+
+// planRollout returns how many services of the current revision of a project
+// to create, and the IDs of the services to remove.
+func planRollout(proj project.Project, services []state.ServiceState) (int, map[string]bool) {
+	count := proj.Configuration.Count
+	maxSurge, maxUnavailable := proj.Configuration.UpdateStrategy.Limits()
+	minHealthy := time.Duration(proj.Configuration.UpdateStrategy.MinHealthySeconds) * time.Second
+
+	live, current, available := 0, 0, 0
+	ended := 0
+	for _, srv := range services {
+		if srv.Draining {
+			// Draining services run until destroyed, so count against the surge
+			live++
+			continue
+		}
+		if isEnded(srv) && srv.Service.Revision == proj.Revision {
+			ended++
+		}
+		if !isLive(srv) {
+			continue
+		}
+		live++
+		if srv.Service.Revision == proj.Revision {
+			current++
+		}
+		if isAvailable(srv, minHealthy) {
+			available++
+		}
+	}
+
+	// Services that ended without being restarted keep their place
+	toCreate := count - current - ended
+	if live-current > 0 && count+maxSurge-live < toCreate {
+		// Mid-update, only surge as far as the strategy allows
+		toCreate = count + maxSurge - live
+	}
+	if toCreate < 0 {
+		toCreate = 0
+	}
+
+	// Services of old revisions are removed as long as enough services stay
+	// available. Ones that are not available yet can always be removed.
+	removable := available - (count - maxUnavailable)
+	excess := current + ended - count
+	remove := map[string]bool{}
+	for _, srv := range services {
+		if srv.Draining {
+			remove[srv.ID] = true
+			continue
+		}
+		if isEnded(srv) && srv.Service.Revision != proj.Revision {
+			remove[srv.ID] = true
+		}
+		if !isLive(srv) {
+			continue
+		}
+
+		if srv.Service.Revision != proj.Revision {
+			if !isAvailable(srv, minHealthy) {
+				remove[srv.ID] = true
+			} else if removable > 0 {
+				remove[srv.ID] = true
+				removable--
+			}
+		}
+	}
+	// Scale down services of the current revision, newest first
+	scaleDown(services, proj.Revision, excess, remove)
+
+	return toCreate, remove
+}
+
+// revisionTarget is how many services of a revision of a project should be
+// running. A count of -1 leaves the services of the revision as they are.
+type revisionTarget struct {
+	project project.Project
+	count   int
+}
+
+// reconcileRevisions creates and removes services of a project so that each
+// target revision runs its count of services. Services of any other revision
+// are removed.
+func (o *Orchestrator) reconcileRevisions(name string, targets []revisionTarget, plan *updatePlan) {
+	delete(o.Unschedulable, name)
+	delete(o.CreateErrors, name)
+
+	targetCounts := map[int]int{}
+	for _, target := range targets {
+		targetCounts[target.project.Revision] = target.count
+	}
+
+	live := map[int]int{}
+	for _, srv := range o.ProjectServices[name] {
+		if !srv.Draining && (isLive(srv) || isEnded(srv)) {
+			live[srv.Service.Revision]++
+		}
+	}
+
+	for _, target := range targets {
+		if target.count < 0 {
+			continue
+		}
+
+		o.scheduleServices(target.project, target.count-live[target.project.Revision], plan)
+	}
+
+	remove := map[string]bool{}
+	for _, srv := range o.ProjectServices[name] {
+		if srv.Draining {
+			remove[srv.ID] = true
+			continue
+		}
+		if _, ok := targetCounts[srv.Service.Revision]; !ok && (isLive(srv) || isEnded(srv)) {
+			remove[srv.ID] = true
+		}
+	}
+	for revision, count := range targetCounts {
+		if count >= 0 {
+			scaleDown(o.ProjectServices[name], revision, live[revision]-count, remove)
+		}
+	}
+
+	o.removeServices(name, remove, plan)
+}
+
+// scaleDown marks n services of a revision for removal, taking services that
+// have ended first and then the newest services.
+func scaleDown(services []state.ServiceState, revision int, n int, remove map[string]bool) {
+	for _, ended := range []bool{true, false} {
+		for i := len(services) - 1; i >= 0 && n > 0; i-- {
+			srv := services[i]
+			if srv.Service.Revision != revision || remove[srv.ID] {
+				continue
+			}
+			if (ended && isEnded(srv)) || (!ended && isLive(srv)) {
+				remove[srv.ID] = true
+				n--
+			}
+		}
+	}
+}
+
+// scheduleServices plans n services of a project to be created. If the
+// services cannot be scheduled or created the reason is recorded against the
+// project, and the rest are left to the next update. Nothing is created
+// while the project is backing off after failures.
+func (o *Orchestrator) scheduleServices(proj project.Project, n int, plan *updatePlan) {
+	if n > 0 && o.backingOff(proj.Name) {
+		return
+	}
+
+	desired := serviceFromProject(proj)
+	for i := 0; i < n; i++ {
+		err := o.placeService(proj, desired, plan)
+		var unschedulable scheduler.UnschedulableError
+		if errors.As(err, &unschedulable) {
+			log.WithFields(log.Fields{
+				"name":   proj.Name,
+				"reason": unschedulable.Reason,
+			}).Error("Could not schedule service")
+			o.Unschedulable[proj.Name] = unschedulable.Reason
+			return
+		}
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"name": proj.Name,
+			}).Error("Could not create service")
+			o.CreateErrors[proj.Name] = err.Error()
+			return
+		}
+	}
+}
+
+// removeServices drains the services of a project with the given IDs, taking
+// them out of routing, and plans the services that were already draining to
+// be destroyed, so that the routes without a service are published before it
+// is destroyed. Destroyed services are dropped from state, and kept to be
+// retried if they cannot be.
+func (o *Orchestrator) removeServices(projectName string, remove map[string]bool, plan *updatePlan) {
+	if len(remove) == 0 {
+		return
+	}
+
+	kept := []state.ServiceState{}
+	for _, srv := range o.ProjectServices[projectName] {
+		if !remove[srv.ID] {
+			kept = append(kept, srv)
+			continue
+		}
+		if isEnded(srv) {
+			// The container was already destroyed when the service ended
+			continue
+		}
+		if !srv.Draining {
+			log.WithFields(log.Fields{
+				"id":       srv.ID,
+				"name":     srv.Name,
+				"revision": srv.Service.Revision,
+			}).Info("Draining service")
+			srv.Draining = true
+			kept = append(kept, srv)
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"id":       srv.ID,
+			"name":     srv.Name,
+			"revision": srv.Service.Revision,
+		}).Info("Removing service")
+		o.destroyService(projectName, srv, true, plan)
+		kept = append(kept, srv)
+	}
+
+	o.ProjectServices[projectName] = kept
+}
+
+// isLive reports whether a service is running or starting.
+func isLive(srv state.ServiceState) bool {
+	return srv.Status == status.RUNNING || srv.Status == status.CREATED
+}
+
+// isEnded reports whether a service exited or failed and was not restarted.
+func isEnded(srv state.ServiceState) bool {
+	return srv.Status == status.COMPLETED || srv.Status == status.FAILED
+}
+
+// isAvailable reports whether a service is ready and has been running for at
+// least minHealthy, and so can take traffic in place of another.
+func isAvailable(srv state.ServiceState, minHealthy time.Duration) bool {
+	return !srv.Draining && srv.Status == status.RUNNING && srv.Ready && !srv.RunningSince.IsZero() &&
+		time.Since(srv.RunningSince) >= minHealthy
+}
@@ -0,0 +1,191 @@
+package orchestrator
+
+import (
+	"metis/pkg/project"
+	"metis/pkg/service"
+	"metis/pkg/state"
+	"metis/pkg/status"
+	"sort"
+	"testing"
+	"time"
+)
+
+func serviceState(id string, revision int, srvStatus status.ServiceStatus, available bool) state.ServiceState {
+	srv := state.ServiceState{
+		ID:      id,
+		Status:  srvStatus,
+		Service: service.DockerService{SrvName: "web", Revision: revision},
+	}
+	if available {
+		srv.Ready = true
+		srv.RunningSince = time.Now().Add(-time.Minute)
+	}
+
+	return srv
+}
+
+func removedIDs(remove map[string]bool) []string {
+	ids := []string{}
+	for id := range remove {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func TestPlanRollout(t *testing.T) {
+	tests := []struct {
+		name       string
+		count      int
+		strategy   project.UpdateStrategy
+		services   []state.ServiceState
+		wantCreate int
+		wantRemove []string
+	}{
+		{
+			name:       "new project",
+			count:      3,
+			wantCreate: 3,
+		},
+		{
+			name:  "steady state",
+			count: 2,
+			services: []state.ServiceState{
+				serviceState("a", 2, status.RUNNING, true),
+				serviceState("b", 2, status.RUNNING, true),
+			},
+		},
+		{
+			name:  "scale down removes newest first",
+			count: 2,
+			services: []state.ServiceState{
+				serviceState("a", 2, status.RUNNING, true),
+				serviceState("b", 2, status.RUNNING, true),
+				serviceState("c", 2, status.RUNNING, true),
+			},
+			wantRemove: []string{"c"},
+		},
+		{
+			name:  "update surges one at a time by default",
+			count: 3,
+			services: []state.ServiceState{
+				serviceState("a", 1, status.RUNNING, true),
+				serviceState("b", 1, status.RUNNING, true),
+				serviceState("c", 1, status.RUNNING, true),
+			},
+			wantCreate: 1,
+		},
+		{
+			name:  "old services are removed once new ones are available",
+			count: 3,
+			services: []state.ServiceState{
+				serviceState("a", 1, status.RUNNING, true),
+				serviceState("b", 1, status.RUNNING, true),
+				serviceState("c", 1, status.RUNNING, true),
+				serviceState("d", 2, status.RUNNING, true),
+			},
+			wantRemove: []string{"a"},
+		},
+		{
+			name:  "new services that are not available hold back removals",
+			count: 3,
+			services: []state.ServiceState{
+				serviceState("a", 1, status.RUNNING, true),
+				serviceState("b", 1, status.RUNNING, true),
+				serviceState("c", 1, status.RUNNING, true),
+				serviceState("d", 2, status.CREATED, false),
+			},
+		},
+		{
+			name:     "max surge",
+			count:    3,
+			strategy: project.UpdateStrategy{MaxSurge: 2},
+			services: []state.ServiceState{
+				serviceState("a", 1, status.RUNNING, true),
+				serviceState("b", 1, status.RUNNING, true),
+				serviceState("c", 1, status.RUNNING, true),
+			},
+			wantCreate: 2,
+		},
+		{
+			name:     "max unavailable removes before creating",
+			count:    3,
+			strategy: project.UpdateStrategy{MaxUnavailable: 1},
+			services: []state.ServiceState{
+				serviceState("a", 1, status.RUNNING, true),
+				serviceState("b", 1, status.RUNNING, true),
+				serviceState("c", 1, status.RUNNING, true),
+			},
+			wantRemove: []string{"a"},
+		},
+		{
+			name:  "unavailable old services are always removed",
+			count: 2,
+			services: []state.ServiceState{
+				serviceState("a", 1, status.CREATED, false),
+				serviceState("b", 2, status.RUNNING, true),
+				serviceState("c", 2, status.RUNNING, true),
+			},
+			wantRemove: []string{"a"},
+		},
+		{
+			name:  "ended services keep their place",
+			count: 2,
+			services: []state.ServiceState{
+				serviceState("a", 2, status.COMPLETED, false),
+				serviceState("b", 2, status.RUNNING, true),
+			},
+		},
+		{
+			name:  "ended services of old revisions are removed",
+			count: 1,
+			services: []state.ServiceState{
+				serviceState("a", 1, status.FAILED, false),
+				serviceState("b", 2, status.RUNNING, true),
+			},
+			wantRemove: []string{"a"},
+		},
+		{
+			name:  "draining services are removed and count against the surge",
+			count: 2,
+			services: []state.ServiceState{
+				func() state.ServiceState {
+					srv := serviceState("a", 1, status.RUNNING, true)
+					srv.Draining = true
+					return srv
+				}(),
+				serviceState("b", 1, status.RUNNING, true),
+				serviceState("c", 2, status.RUNNING, true),
+			},
+			wantRemove: []string{"a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proj := project.Project{
+				Name:     "web",
+				Revision: 2,
+				Configuration: project.ProjectConfiguration{
+					Count:          test.count,
+					UpdateStrategy: test.strategy,
+				},
+			}
+
+			toCreate, remove := planRollout(proj, test.services)
+			if toCreate != test.wantCreate {
+				t.Errorf("got %d services to create, want %d", toCreate, test.wantCreate)
+			}
+			got := removedIDs(remove)
+			if len(got) != len(test.wantRemove) {
+				t.Fatalf("got %v removed, want %v", got, test.wantRemove)
+			}
+			for i := range got {
+				if got[i] != test.wantRemove[i] {
+					t.Errorf("got %v removed, want %v", got, test.wantRemove)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"metis/pkg/project"
+	"metis/pkg/routing"
+	"metis/pkg/state"
+	"metis/pkg/status"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Strum355/log"
+	"github.com/spf13/viper"
+)
+
+var ErrBackendNotFound = errors.New("router backend not enabled")
+
+// routeCache holds the configuration rendered by each router backend between
+// updates, so that it can be served without building it for every request.
+type routeCache struct {
+	mu       sync.RWMutex
+	backends []routing.RouterBackend
+	configs  map[string]*renderedConfig
+}
+
+type renderedConfig struct {
+	config      []byte
+	etag        string
+	contentType string
+	version     int
+	// published is whether the configuration has been written to disk and
+	// pushed to the backend, retried every update until it succeeds
+	published bool
+}
+
+func newRouteCache() *routeCache {
+	return &routeCache{
+		backends: []routing.RouterBackend{routing.TraefikBackend{}},
+		configs:  make(map[string]*renderedConfig),
+	}
+}
+
+// SetRouterBackends sets the backends the routing table is rendered for,
+// Traefik only by default.
+func (o *Orchestrator) SetRouterBackends(backends []routing.RouterBackend) {
+	o.routes.mu.Lock()
+	defer o.routes.mu.Unlock()
+
+	o.routes.backends = backends
+	o.routes.configs = make(map[string]*renderedConfig)
+}
+
+// RoutingTable builds the routing of every project from the state of the last
+// update.
+func (o *Orchestrator) RoutingTable() routing.Table {
+	table := routing.Table{}
+	for _, proj := range o.Projects {
+		ready := o.readyServices(proj.Name)
+		weights := o.trafficWeights(proj)
+
+		for _, port := range proj.Configuration.RoutedPorts() {
+			route := routing.Route{
+				Name:    routeName(proj.Name, port),
+				Port:    port,
+				Weights: weights,
+				Servers: map[int][]string{},
+			}
+			for _, srv := range ready {
+				nodePort, ok := srv.PortFor(port.Name)
+				if !ok {
+					continue
+				}
+				revision := srv.Service.Revision
+				address := fmt.Sprintf("%s:%d", o.Nodes[srv.Node].Address, nodePort)
+				route.Servers[revision] = append(route.Servers[revision], address)
+			}
+			table.Routes = append(table.Routes, route)
+		}
+	}
+
+	return table
+}
+
+// RefreshRoutes renders the routing table for every backend. The cached
+// configuration of a backend and its ETag only change when the configuration
+// does, and only then is it written to metis.routing.<backend>.file and
+// pushed to backends that take pushes.
+func (o *Orchestrator) RefreshRoutes() error {
+	o.guard.update.Lock()
+	defer o.guard.update.Unlock()
+
+	return o.refreshRoutes()
+}
+
+// refreshRoutes renders the routing table of the current snapshot, so that
+// it does not need the state lock.
+func (o *Orchestrator) refreshRoutes() error {
+	pending, err := o.routes.render(o.Snapshot().RoutingTable())
+	if err != nil {
+		return err
+	}
+
+	// Publishing may wait on a backend, so it is done without holding the
+	// cache, which keeps serving the new configuration meanwhile
+	for _, p := range pending {
+		err := publish(p.backend, p.config)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"backend": p.backend.Name(),
+			}).Error("Could not publish routing configuration")
+			continue
+		}
+		o.routes.markPublished(p.backend.Name(), p.version)
+	}
+
+	return nil
+}
+
+// pendingConfig is a rendered configuration that has not been published.
+type pendingConfig struct {
+	backend routing.RouterBackend
+	config  []byte
+	version int
+}
+
+// render renders the routing table for every backend, swapping in the
+// configurations that changed, and returns those still to be published.
+func (c *routeCache) render(table routing.Table) ([]pendingConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := []pendingConfig{}
+	for _, backend := range c.backends {
+		config, err := backend.Render(table)
+		if err != nil {
+			return nil, err
+		}
+
+		rendered, ok := c.configs[backend.Name()]
+		if !ok {
+			rendered = &renderedConfig{contentType: backend.ContentType()}
+			c.configs[backend.Name()] = rendered
+		}
+
+		if rendered.etag == "" || string(config) != string(rendered.config) {
+			sum := sha256.Sum256(config)
+			rendered.config = config
+			rendered.etag = fmt.Sprintf("%q", hex.EncodeToString(sum[:8]))
+			rendered.version++
+			rendered.published = false
+			log.WithFields(log.Fields{
+				"backend": backend.Name(),
+				"version": rendered.version,
+				"etag":    rendered.etag,
+			}).Info("Routing configuration changed")
+		}
+
+		if !rendered.published {
+			pending = append(pending, pendingConfig{
+				backend: backend,
+				config:  rendered.config,
+				version: rendered.version,
+			})
+		}
+	}
+
+	return pending, nil
+}
+
+// markPublished records that a version of a backend's configuration was
+// published, unless it has changed since.
+func (c *routeCache) markPublished(backend string, version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rendered, ok := c.configs[backend]; ok && rendered.version == version {
+		rendered.published = true
+	}
+}
+
+// RouterConfig returns the cached configuration of a backend, its ETag and
+// its content type.
+func (o *Orchestrator) RouterConfig(backend string) ([]byte, string, string, error) {
+	o.routes.mu.RLock()
+	defer o.routes.mu.RUnlock()
+
+	rendered, ok := o.routes.configs[backend]
+	if !ok {
+		return nil, "", "", ErrBackendNotFound
+	}
+
+	return rendered.config, rendered.etag, rendered.contentType, nil
+}
+
+// publish writes the configuration of a backend to its file, if one is set,
+// and pushes it to the backend if it takes pushes.
+func publish(backend routing.RouterBackend, config []byte) error {
+	if file := viper.GetString("metis.routing." + backend.Name() + ".file"); file != "" {
+		// Write to a temporary file first so the backend never reads a
+		// partial configuration
+		err := ioutil.WriteFile(file+".tmp", config, 0644)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(file+".tmp", file)
+		if err != nil {
+			return err
+		}
+	}
+
+	if pusher, ok := backend.(routing.Pusher); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		return pusher.Push(ctx, config)
+	}
+
+	return nil
+}
+
+// readyServices returns the services of a project which were running and
+// ready to be routed to at the last update. Draining services are left out.
+func (o *Orchestrator) readyServices(projectName string) []state.ServiceState {
+	ready := []state.ServiceState{}
+	for _, service := range o.ProjectServices[projectName] {
+		if !o.Nodes[service.Node].Healthy {
+			continue
+		}
+		if service.Status != status.RUNNING || !service.Ready || service.Draining {
+			continue
+		}
+		ready = append(ready, service)
+	}
+
+	return ready
+}
+
+// routeName returns the name of the router and service of a project's port.
+// The single port of a project without named ports is routed under the
+// project's name. Neither project nor port names may contain the separator,
+// so that no two ports share a name.
+func routeName(projectName string, port project.Port) string {
+	if port.Name == "" {
+		return projectName
+	}
+
+	return projectName + routing.Separator + port.Name
+}
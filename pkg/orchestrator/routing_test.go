@@ -0,0 +1,52 @@
+package orchestrator
+
+import (
+	"metis/pkg/project"
+	"metis/pkg/routing"
+	"strings"
+	"testing"
+)
+
+func TestRoutingTableNames(t *testing.T) {
+	o := NewOrchestrator()
+	projects := []project.Project{
+		{
+			Name: "web",
+			Configuration: project.ProjectConfiguration{ImageName: "web:1", Count: 1, Ports: []project.Port{
+				{Name: "api", ContainerPort: 8080, Host: "api.example.com"},
+			}},
+		},
+		{
+			Name:          "web-api",
+			Configuration: project.ProjectConfiguration{ImageName: "web-api:1", Count: 1, ContainerPort: 80, Host: "example.com"},
+		},
+	}
+	for _, proj := range projects {
+		if err := o.CreateProject(proj); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	table := o.RoutingTable()
+	names := map[string]bool{}
+	for _, route := range table.Routes {
+		if names[route.Name] {
+			t.Errorf("two routes are named %s", route.Name)
+		}
+		names[route.Name] = true
+	}
+	if !names["web:api"] || !names["web-api"] {
+		t.Errorf("routes are named %v, want web:api and web-api", names)
+	}
+
+	// Each route keeps its own router in the rendered configuration
+	config, err := routing.TraefikBackend{}.Render(table)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, router := range []string{`"web:api:router"`, `"web-api:router"`} {
+		if !strings.Contains(string(config), router) {
+			t.Errorf("rendered configuration has no router %s:\n%s", router, config)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"metis/pkg/scheduler"
+	"metis/pkg/secret"
+	"metis/pkg/service"
+
+	"github.com/Strum355/log"
+)
+
+var (
+	ErrSecretsDisabled = errors.New("secrets are not configured")
+	ErrSecretInUse     = errors.New("secret is used by a project")
+)
+
+// SetSecrets sets the store the secrets referenced by projects are read from.
+func (o *Orchestrator) SetSecrets(store *secret.Store) {
+	defer o.lock()()
+
+	o.secrets = store
+}
+
+// GetSecrets returns the secrets in the store, without their values.
+func (o *Orchestrator) GetSecrets() ([]secret.Info, error) {
+	if o.secrets == nil {
+		return nil, ErrSecretsDisabled
+	}
+
+	return o.secrets.List(), nil
+}
+
+// CreateSecret adds a secret to the store.
+func (o *Orchestrator) CreateSecret(name string, value string) (secret.Info, error) {
+	if o.secrets == nil {
+		return secret.Info{}, ErrSecretsDisabled
+	}
+
+	log.WithFields(log.Fields{
+		"name": name,
+	}).Info("Creating secret")
+
+	return o.secrets.Create(name, value)
+}
+
+// RotateSecret replaces the value of a secret. Every project using the secret
+// is given a new revision so that its services are replaced with ones using
+// the new value.
+func (o *Orchestrator) RotateSecret(name string, value string) (secret.Info, error) {
+	defer o.lock()()
+
+	if o.secrets == nil {
+		return secret.Info{}, ErrSecretsDisabled
+	}
+
+	info, err := o.secrets.Rotate(name, value)
+	if err != nil {
+		return info, err
+	}
+
+	log.WithFields(log.Fields{
+		"name":    name,
+		"version": info.Version,
+	}).Info("Rotated secret")
+
+	for _, proj := range o.Projects {
+		if !usesSecret(proj.Configuration.Secrets, name) {
+			continue
+		}
+
+		err = o.updateProject(proj, fmt.Sprintf("secret %s rotated", name), true)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	return info, nil
+}
+
+// DeleteSecret removes a secret from the store. Secrets still used by a
+// project cannot be deleted.
+func (o *Orchestrator) DeleteSecret(name string) error {
+	defer o.lock()()
+
+	if o.secrets == nil {
+		return ErrSecretsDisabled
+	}
+
+	for _, proj := range o.Projects {
+		if usesSecret(proj.Configuration.Secrets, name) {
+			return ErrSecretInUse
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"name": name,
+	}).Info("Deleting secret")
+
+	return o.secrets.Delete(name)
+}
+
+// withSecrets adds the values of the secrets a service references, so they
+// can be sent to the agent with the service.
+func (o *Orchestrator) withSecrets(srv service.Service) (service.Service, error) {
+	dockerSrv, ok := srv.(service.DockerService)
+	if !ok || len(dockerSrv.Secrets) == 0 {
+		return srv, nil
+	}
+	if o.secrets == nil {
+		return srv, scheduler.UnschedulableError{Reason: ErrSecretsDisabled.Error()}
+	}
+
+	dockerSrv.SecretValues = map[string]string{}
+	for _, ref := range dockerSrv.Secrets {
+		value, _, err := o.secrets.Get(ref.Name)
+		if errors.Is(err, secret.ErrSecretNotFound) {
+			return srv, scheduler.UnschedulableError{Reason: fmt.Sprintf("secret %q not found", ref.Name)}
+		}
+		if err != nil {
+			return srv, err
+		}
+		dockerSrv.SecretValues[ref.Name] = value
+	}
+
+	return dockerSrv, nil
+}
+
+func usesSecret(refs []service.SecretRef, name string) bool {
+	for _, ref := range refs {
+		if ref.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package orchestrator
+
+import (
+	"metis/pkg/node"
+	"metis/pkg/project"
+	"metis/pkg/state"
+	"sync"
+	"sync/atomic"
+)
+
+// stateGuard serialises changes to the state of an orchestrator, and holds
+// the snapshot of the state that readers use. Changes are made by the update
+// loop and by API handlers, while reads are made from snapshots so that they
+// never wait on an update.
+type stateGuard struct {
+	mu       sync.Mutex
+	snapshot atomic.Value
+	// update serialises updates, which release mu while calling agents
+	update sync.Mutex
+}
+
+// lock takes the state lock, returning a function that publishes a snapshot
+// of the changed state and releases the lock. Exported methods that change
+// the state start with defer o.lock()(). Steps of an update that call agents
+// instead take the lock themselves, only while reading or changing the state.
+func (o *Orchestrator) lock() func() {
+	o.guard.mu.Lock()
+	return func() {
+		o.publish()
+		o.guard.mu.Unlock()
+	}
+}
+
+// withLock calls fn holding the state lock.
+func (o *Orchestrator) withLock(fn func()) {
+	defer o.lock()()
+
+	fn()
+}
+
+// Snapshot returns a consistent copy of the state as of the last change,
+// which is safe to read from any goroutine. Methods that only read the state,
+// such as GetProject, are not synchronised and should be called on a
+// snapshot outside of the update loop. Snapshots must not be changed.
+func (o *Orchestrator) Snapshot() *Orchestrator {
+	return o.guard.snapshot.Load().(*Orchestrator)
+}
+
+// publish stores a copy of the state as the current snapshot.
+func (o *Orchestrator) publish() {
+	snapshot := &Orchestrator{
+		Projects:        append([]project.Project{}, o.Projects...),
+		ProjectServices: copyServices(o.ProjectServices),
+		Nodes:           make(map[string]node.Node, len(o.Nodes)),
+		LostServices:    copyServices(o.LostServices),
+		Revisions:       make(map[string][]project.Revision, len(o.Revisions)),
+		Deployments:     make(map[string]project.Deployment, len(o.Deployments)),
+		Restarts:        make(map[string]RestartState, len(o.Restarts)),
+		Unschedulable:   make(map[string]string, len(o.Unschedulable)),
+		CreateErrors:    make(map[string]string, len(o.CreateErrors)),
+		Orphans:         make(map[string]Orphan, len(o.Orphans)),
+
+		secrets: o.secrets,
+		routes:  o.routes,
+		guard:   o.guard,
+	}
+	for id, nd := range o.Nodes {
+		nd.Labels = append([]string{}, nd.Labels...)
+		snapshot.Nodes[id] = nd
+	}
+	for name, revisions := range o.Revisions {
+		snapshot.Revisions[name] = append([]project.Revision{}, revisions...)
+	}
+	for name, deployment := range o.Deployments {
+		snapshot.Deployments[name] = deployment
+	}
+	for name, restarts := range o.Restarts {
+		snapshot.Restarts[name] = restarts
+	}
+	for name, reason := range o.Unschedulable {
+		snapshot.Unschedulable[name] = reason
+	}
+	for name, reason := range o.CreateErrors {
+		snapshot.CreateErrors[name] = reason
+	}
+	for id, orphan := range o.Orphans {
+		snapshot.Orphans[id] = orphan
+	}
+
+	o.guard.snapshot.Store(snapshot)
+}
+
+func copyServices(services map[string][]state.ServiceState) map[string][]state.ServiceState {
+	copied := make(map[string][]state.ServiceState, len(services))
+	for key, srvs := range services {
+		copied[key] = append([]state.ServiceState{}, srvs...)
+	}
+
+	return copied
+}
@@ -0,0 +1,299 @@
+package orchestrator
+
+import (
+	"fmt"
+	"metis/pkg/node"
+	"metis/pkg/project"
+	"metis/pkg/routing"
+	"metis/pkg/status"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestOrchestrator(t *testing.T, agent *stubAgent, projects ...string) *Orchestrator {
+	o := NewOrchestrator()
+	nd := agent.node("node-a")
+	o.Nodes[nd.ID] = nd
+	for _, name := range projects {
+		err := o.CreateProject(project.Project{
+			Name:          name,
+			Configuration: project.ProjectConfiguration{ImageName: name + ":1", Count: 2},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	return &o
+}
+
+func TestUpdateContinuesAfterCreateError(t *testing.T) {
+	agent := newStubAgent(t)
+	agent.setFailCreate("api", true)
+	o := newTestOrchestrator(t, agent, "api", "web")
+
+	err := o.Update()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	snapshot := o.Snapshot()
+	if got := len(snapshot.ProjectServices["web"]); got != 2 {
+		t.Errorf("web has %d services, want 2", got)
+	}
+	if got := len(snapshot.ProjectServices["api"]); got != 0 {
+		t.Errorf("api has %d services, want 0", got)
+	}
+	if snapshot.CreateErrors["api"] == "" {
+		t.Error("the create error of api was not recorded")
+	}
+
+	// The error is cleared once the services are created
+	agent.setFailCreate("api", false)
+	err = o.Update()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	snapshot = o.Snapshot()
+	if got := len(snapshot.ProjectServices["api"]); got != 2 {
+		t.Errorf("api has %d services, want 2", got)
+	}
+	if reason, ok := snapshot.CreateErrors["api"]; ok {
+		t.Errorf("api still has create error %q", reason)
+	}
+}
+
+func TestUpdateKeepsStatusOnNodeError(t *testing.T) {
+	agent := newStubAgent(t)
+	o := newTestOrchestrator(t, agent, "web")
+
+	err := o.Update()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before := o.Snapshot().ProjectServices["web"]
+
+	agent.setFailStatuses(true)
+	err = o.Update()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	after := o.Snapshot().ProjectServices["web"]
+	if len(after) != len(before) {
+		t.Fatalf("web has %d services, want %d", len(after), len(before))
+	}
+	for i, srv := range after {
+		if srv.ID != before[i].ID || srv.Status != status.RUNNING {
+			t.Errorf("service %s is %s, want %s to be kept running", srv.ID, srv.Status, before[i].ID)
+		}
+	}
+	if failures := o.Snapshot().Restarts["web"].Failures; failures != 0 {
+		t.Errorf("the failed request was counted as %d restarts", failures)
+	}
+}
+
+func TestUpdateDoesNotBlockWrites(t *testing.T) {
+	agent := newStubAgent(t)
+	agent.creating = make(chan struct{})
+	agent.release = make(chan struct{})
+	o := newTestOrchestrator(t, agent, "web")
+
+	done := make(chan error)
+	go func() {
+		done <- o.Update()
+	}()
+	<-agent.creating
+
+	// The update is waiting on the agent, while writes go ahead
+	written := make(chan error)
+	go func() {
+		written <- o.CreateProject(project.Project{
+			Name:          "api",
+			Configuration: project.ProjectConfiguration{ImageName: "api:1", Count: 1},
+		})
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating a project waited on the update")
+	}
+	if _, err := o.Snapshot().GetProject("api"); err != nil {
+		t.Errorf("created project is not in the snapshot: %v", err)
+	}
+
+	close(agent.release)
+	go func() {
+		for range agent.creating {
+		}
+	}()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := len(o.Snapshot().ProjectServices["web"]); got != 2 {
+		t.Errorf("web has %d services, want 2", got)
+	}
+}
+
+func TestUpdateDrainsBeforeDestroying(t *testing.T) {
+	agent := newStubAgent(t)
+	o := newTestOrchestrator(t, agent)
+	web := project.Project{
+		Name:          "web",
+		Configuration: project.ProjectConfiguration{ImageName: "web:1", Count: 1, ContainerPort: 80, Host: "web.example.com"},
+	}
+	if err := o.CreateProject(web); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := o.Update(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	web.Configuration.ImageName = "web:2"
+	if err := o.UpdateProject(web); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	draining := func() bool {
+		for _, srv := range o.Snapshot().ProjectServices["web"] {
+			if srv.Draining {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < 5 && !draining(); i++ {
+		if err := o.Update(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !draining() {
+		t.Fatal("the old service was not drained")
+	}
+
+	// The old service is out of the routes, but still running
+	snapshot := o.Snapshot()
+	proj, _ := snapshot.GetProject("web")
+	for revision := range snapshot.RoutingTable().Routes[0].Servers {
+		if revision != proj.Revision {
+			t.Errorf("revision %d is routed to, want only %d", revision, proj.Revision)
+		}
+	}
+	if got := agent.count(); got != 2 {
+		t.Errorf("agent runs %d containers, want 2", got)
+	}
+
+	// and is destroyed on the next update
+	if err := o.Update(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if draining() {
+		t.Error("the drained service was not removed")
+	}
+	if got := agent.count(); got != 1 {
+		t.Errorf("agent runs %d containers, want 1", got)
+	}
+}
+
+func TestUpdateConcurrently(t *testing.T) {
+	agent := newStubAgent(t)
+	o := newTestOrchestrator(t, agent)
+	o.Nodes = map[string]node.Node{}
+	nd := o.RegisterNode(agent.node("node-a"))
+	o.SetRouterBackends([]routing.RouterBackend{routing.TraefikBackend{}, routing.NginxBackend{}})
+
+	web := project.Project{
+		Name:          "web",
+		Configuration: project.ProjectConfiguration{ImageName: "web:1", Count: 2, ContainerPort: 80, Host: "web.example.com"},
+	}
+	if err := o.CreateProject(web); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	writer := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				fn(i)
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	writer(func(i int) {
+		proj := web
+		proj.Configuration.ImageName = fmt.Sprintf("web:%d", i%3)
+		_ = o.UpdateProject(proj)
+	})
+	writer(func(i int) {
+		tmp := project.Project{
+			Name:          "tmp",
+			Configuration: project.ProjectConfiguration{ImageName: "tmp:1", Count: 1},
+		}
+		if i%2 == 0 {
+			_ = o.CreateProject(tmp)
+		} else {
+			_ = o.DestroyProject(tmp)
+		}
+	})
+	writer(func(int) {
+		if err := o.Heartbeat(nd.ID); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	writer(func(int) {
+		snapshot := o.Snapshot()
+		for _, proj := range snapshot.Projects {
+			_, _ = snapshot.CountHealthy(proj.Name)
+		}
+		_ = snapshot.GetServices()
+		_, _, _, _ = o.RouterConfig(routing.TRAEFIK)
+	})
+
+	for i := 0; i < 20; i++ {
+		if err := o.Update(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// Once writes stop, the cluster settles with no containers leaked. Each
+	// update surges one service of the current revision at most.
+	_ = o.DestroyProject(project.Project{Name: "tmp"})
+	for i := 0; i < 10; i++ {
+		if err := o.Update(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	snapshot := o.Snapshot()
+	services := snapshot.ProjectServices["web"]
+	if len(services) != 2 {
+		t.Errorf("web has %d services, want 2", len(services))
+	}
+	proj, _ := snapshot.GetProject("web")
+	for _, srv := range services {
+		if srv.Service.Revision != proj.Revision {
+			t.Errorf("service %s is at revision %d, want %d", srv.ID, srv.Service.Revision, proj.Revision)
+		}
+	}
+	if got := agent.count(); got != len(services) {
+		t.Errorf("agent runs %d containers for %d services", got, len(services))
+	}
+	if _, _, _, err := o.RouterConfig(routing.NGINX); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package orchestrator
+
+import (
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// forEach calls fn with each index below n on at most metis.controller.workers
+// goroutines at once, and returns once every call has finished. fn must not
+// modify the orchestrator, and should write its result to the index it was
+// given.
+func forEach(n int, fn func(i int)) {
+	workers := viper.GetInt("metis.controller.workers")
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,225 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"metis/pkg/service"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Strum355/log"
+)
+
+// Target is a running service that probes are run against.
+type Target struct {
+	// Address is the host the service's ports can be reached on
+	Address string
+	// Exec runs a command in the service, returning its exit code
+	Exec func(ctx context.Context, cmd []string) (int, error)
+}
+
+// Manager runs the liveness and readiness probes of services, keyed by
+// container ID.
+type Manager struct {
+	mu      sync.Mutex
+	workers map[string]*worker
+}
+
+func NewManager() *Manager {
+	return &Manager{workers: make(map[string]*worker)}
+}
+
+// Start begins probing a service. Services that are already being probed are
+// left as they are.
+func (m *Manager) Start(id string, srv service.DockerService, target Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.workers[id]; ok {
+		return
+	}
+
+	w := &worker{
+		id:     id,
+		srv:    srv,
+		target: target,
+		live:   true,
+		ready:  srv.ReadinessProbe == nil,
+		stopCh: make(chan struct{}),
+	}
+	m.workers[id] = w
+
+	if srv.LivenessProbe != nil {
+		go w.run(*srv.LivenessProbe, true, w.setLive)
+	}
+	if srv.ReadinessProbe != nil {
+		go w.run(*srv.ReadinessProbe, false, w.setReady)
+	}
+}
+
+// Stop stops probing a service.
+func (m *Manager) Stop(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w, ok := m.workers[id]; ok {
+		close(w.stopCh)
+		delete(m.workers, id)
+	}
+}
+
+// Status returns whether a service is passing its liveness and readiness
+// probes. The last value is false if the service is not being probed.
+func (m *Manager) Status(id string) (live bool, ready bool, ok bool) {
+	m.mu.Lock()
+	w, ok := m.workers[id]
+	m.mu.Unlock()
+	if !ok {
+		return false, false, false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.live, w.ready, true
+}
+
+type worker struct {
+	id     string
+	srv    service.DockerService
+	target Target
+	stopCh chan struct{}
+
+	mu    sync.Mutex
+	live  bool
+	ready bool
+}
+
+// run checks a probe every interval, calling set when the probe starts or
+// stops passing. Liveness probes start out passing, while readiness probes
+// must pass before a service is ready.
+func (w *worker) run(probe service.Probe, passing bool, set func(bool)) {
+	select {
+	case <-w.stopCh:
+		return
+	case <-time.After(probe.InitialDelay()):
+	}
+
+	successes, failures := 0, 0
+	for {
+		err := w.check(probe)
+		if err == nil {
+			successes++
+			failures = 0
+		} else {
+			failures++
+			successes = 0
+			log.WithError(err).WithFields(log.Fields{
+				"id": w.id,
+			}).Debug("Probe failed")
+		}
+
+		if !passing && successes >= probe.Successes() {
+			passing = true
+			set(true)
+		} else if passing && failures >= probe.Failures() {
+			passing = false
+			set(false)
+		}
+
+		select {
+		case <-w.stopCh:
+			return
+		case <-time.After(probe.Interval()):
+		}
+	}
+}
+
+func (w *worker) setLive(live bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.live != live {
+		log.WithFields(log.Fields{
+			"id":   w.id,
+			"live": live,
+		}).Info("Liveness probe changed")
+	}
+	w.live = live
+}
+
+func (w *worker) setReady(ready bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.ready != ready {
+		log.WithFields(log.Fields{
+			"id":    w.id,
+			"ready": ready,
+		}).Info("Readiness probe changed")
+	}
+	w.ready = ready
+}
+
+func (w *worker) check(probe service.Probe) error {
+	ctx, cancel := context.WithTimeout(context.Background(), probe.Timeout())
+	defer cancel()
+
+	switch {
+	case probe.HTTP != nil:
+		return w.checkHTTP(ctx, *probe.HTTP)
+	case probe.TCP != nil:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", w.address(probe.TCP.Port))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case probe.Exec != nil:
+		code, err := w.target.Exec(ctx, probe.Exec.Command)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("command exited with code %d", code)
+		}
+		return nil
+	}
+
+	return errors.New("probe has no check")
+}
+
+func (w *worker) checkHTTP(ctx context.Context, probe service.HTTPProbe) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s%s", w.address(probe.Port), probe.Path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if probe.ExpectedStatus != 0 && resp.StatusCode != probe.ExpectedStatus {
+		return fmt.Errorf("expected status %d, got %d", probe.ExpectedStatus, resp.StatusCode)
+	}
+	if probe.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// address returns the host and port to probe, defaulting to the container
+// port of the service.
+func (w *worker) address(port int) string {
+	if port == 0 {
+		port = w.srv.ContainerPort
+	}
+
+	return net.JoinHostPort(w.target.Address, strconv.Itoa(port))
+}
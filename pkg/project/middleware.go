@@ -0,0 +1,117 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TLS serves an HTTP route over HTTPS, with a certificate from a Traefik
+// certificate resolver, from files on the router's host, or Traefik's default
+// certificate if neither is given.
+type TLS struct {
+	// EntryPoints are the entrypoints HTTPS is served on
+	EntryPoints  []string `json:"entrypoints"`
+	CertResolver string   `json:"cert_resolver"`
+	CertFile     string   `json:"cert_file"`
+	KeyFile      string   `json:"key_file"`
+	// Options names a set of Traefik TLS options
+	Options string `json:"options"`
+	// RedirectFrom are entrypoints on which plain HTTP requests are
+	// redirected to HTTPS
+	RedirectFrom []string `json:"redirect_from"`
+}
+
+func (t TLS) Validate() error {
+	if len(t.EntryPoints) == 0 {
+		return errors.New("tls needs entrypoints")
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	if t.CertResolver != "" && t.CertFile != "" {
+		return errors.New("use either cert_resolver or cert_file and key_file")
+	}
+
+	return nil
+}
+
+// Middleware changes requests before they reach a service, or their
+// responses. Exactly one of the fields is set.
+type Middleware struct {
+	RedirectScheme *RedirectScheme `json:"redirect_scheme"`
+	BasicAuth      *BasicAuth      `json:"basic_auth"`
+	RateLimit      *RateLimit      `json:"rate_limit"`
+	Compress       bool            `json:"compress"`
+	Headers        *Headers        `json:"headers"`
+}
+
+type RedirectScheme struct {
+	// Scheme defaults to https
+	Scheme    string `json:"scheme"`
+	Permanent bool   `json:"permanent"`
+}
+
+type BasicAuth struct {
+	// Users are htpasswd entries, e.g. from htpasswd -nb user password
+	Users []string `json:"users"`
+	Realm string   `json:"realm"`
+}
+
+// RateLimit allows Average requests per PeriodSeconds from each client, with
+// bursts of up to Burst requests.
+type RateLimit struct {
+	Average int `json:"average"`
+	// PeriodSeconds defaults to 1
+	PeriodSeconds int `json:"period_seconds"`
+	Burst         int `json:"burst"`
+}
+
+// Headers sets request headers before they reach the service and response
+// headers before they reach the client. An empty value removes the header.
+type Headers struct {
+	Request  map[string]string `json:"request"`
+	Response map[string]string `json:"response"`
+}
+
+func (m Middleware) Validate() error {
+	set := 0
+	if m.RedirectScheme != nil {
+		set++
+	}
+	if m.BasicAuth != nil {
+		set++
+		if len(m.BasicAuth.Users) == 0 {
+			return errors.New("basic_auth needs users")
+		}
+	}
+	if m.RateLimit != nil {
+		set++
+		if m.RateLimit.Average <= 0 {
+			return errors.New("rate_limit needs a positive average")
+		}
+		if m.RateLimit.PeriodSeconds < 0 || m.RateLimit.Burst < 0 {
+			return errors.New("rate_limit cannot be negative")
+		}
+	}
+	if m.Compress {
+		set++
+	}
+	if m.Headers != nil {
+		set++
+		for key := range m.Headers.Request {
+			if key == "" {
+				return fmt.Errorf("invalid request header %q", key)
+			}
+		}
+		for key := range m.Headers.Response {
+			if key == "" {
+				return fmt.Errorf("invalid response header %q", key)
+			}
+		}
+	}
+	if set != 1 {
+		return errors.New("middleware needs exactly one of redirect_scheme, basic_auth, rate_limit, compress or headers")
+	}
+
+	return nil
+}
@@ -1,8 +1,22 @@
 package project
 
+import (
+	"errors"
+	"fmt"
+	"metis/pkg/service"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
 type Project struct {
 	Name          string               `json:"name"`
 	Configuration ProjectConfiguration `json:"configuration"`
+
+	// Revision is increased by the controller every time the configuration
+	// changes.
+	Revision int `json:"revision"`
 }
 
 type ProjectConfiguration struct {
@@ -10,4 +24,401 @@ type ProjectConfiguration struct {
 	Count         int    `json:"count"`
 	ContainerPort int    `json:"container_port"`
 	Host          string `json:"host"`
+	// HTTPRoute adds hosts, path prefixes, header and method matchers to the
+	// route to ContainerPort
+	HTTPRoute
+	// Ports replaces ContainerPort and the HTTP route for projects exposing
+	// more than one port, or TCP or UDP ports
+	Ports []Port `json:"ports"`
+
+	// Env sets environment variables in the container
+	Env map[string]string `json:"env"`
+	// Entrypoint and Command replace the image's ENTRYPOINT and CMD, and Args
+	// are appended to the command
+	Entrypoint []string `json:"entrypoint"`
+	Command    []string `json:"command"`
+	Args       []string `json:"args"`
+	WorkingDir string   `json:"working_dir"`
+	User       string   `json:"user"`
+
+	Mounts []service.Mount `json:"mounts"`
+
+	// Secrets are injected from the controller's secrets store
+	Secrets []service.SecretRef `json:"secrets"`
+
+	Placement Placement                    `json:"placement"`
+	Resources service.ResourceRequirements `json:"resources"`
+
+	UpdateStrategy UpdateStrategy `json:"update_strategy"`
+
+	// Services failing their liveness probe are replaced, and services are
+	// only routed to while passing their readiness probe
+	LivenessProbe  *service.Probe `json:"liveness_probe"`
+	ReadinessProbe *service.Probe `json:"readiness_probe"`
+
+	RestartPolicy RestartPolicy `json:"restart_policy"`
+}
+
+// Port is a named container port of a project and how traffic is routed to
+// it.
+type Port struct {
+	Name          string `json:"name"`
+	ContainerPort int    `json:"container_port"`
+	// Protocol is one of http (the default), tcp or udp
+	Protocol string `json:"protocol"`
+	// Host routes HTTP traffic for the host name to the port, along with
+	// the hosts and any other matchers of the HTTP route
+	Host string `json:"host"`
+	HTTPRoute
+	// SNI routes TCP traffic by TLS server name to the port, passing the TLS
+	// connection through. Defaults to *, which matches all traffic.
+	SNI string `json:"sni"`
+	// EntryPoints are the Traefik entrypoints the port is routed from,
+	// required for TCP and UDP ports
+	EntryPoints []string `json:"entrypoints"`
+}
+
+// RoutedPorts returns the ports of the project, or a single unnamed HTTP port
+// from ContainerPort and Host if it does not list any.
+func (c ProjectConfiguration) RoutedPorts() []Port {
+	if len(c.Ports) > 0 {
+		return c.Ports
+	}
+
+	return []Port{{
+		ContainerPort: c.ContainerPort,
+		Protocol:      service.HTTP,
+		Host:          c.Host,
+		HTTPRoute:     c.HTTPRoute,
+	}}
+}
+
+// AllHosts returns Host followed by the hosts of the port's HTTP route.
+func (p Port) AllHosts() []string {
+	if p.Host == "" {
+		return p.Hosts
+	}
+
+	return append([]string{p.Host}, p.Hosts...)
+}
+
+func (p Port) Validate() error {
+	if p.ContainerPort <= 0 {
+		return errors.New("container_port is required")
+	}
+
+	switch p.Protocol {
+	case "", service.HTTP:
+		if len(p.AllHosts()) == 0 && len(p.PathPrefixes) == 0 {
+			return errors.New("http ports need a host or path prefix")
+		}
+		if p.SNI != "" {
+			return errors.New("sni is only supported by tcp ports")
+		}
+		if p.Host != "" && !validHost(p.Host) {
+			return fmt.Errorf("invalid host %q", p.Host)
+		}
+		if err := p.HTTPRoute.Validate(); err != nil {
+			return err
+		}
+	case service.TCP:
+		if p.Host != "" || !p.HTTPRoute.IsZero() {
+			return errors.New("host and http matchers are only supported by http ports")
+		}
+		if strings.Contains(p.SNI, "`") {
+			return fmt.Errorf("invalid sni %q", p.SNI)
+		}
+	case service.UDP:
+		if p.Host != "" || !p.HTTPRoute.IsZero() || p.SNI != "" {
+			return errors.New("udp ports do not support host, http matchers or sni")
+		}
+	default:
+		return fmt.Errorf("unknown protocol %q", p.Protocol)
+	}
+	if (p.Protocol == service.TCP || p.Protocol == service.UDP) && len(p.EntryPoints) == 0 {
+		return fmt.Errorf("%s ports need entrypoints", p.Protocol)
+	}
+
+	return nil
+}
+
+const (
+	ALWAYS    = "always"
+	ONFAILURE = "on-failure"
+	NEVER     = "never"
+)
+
+// RestartPolicy controls whether services that exit or become unhealthy are
+// replaced, and how long to back off between replacements.
+type RestartPolicy struct {
+	// Policy is one of always (the default), on-failure or never. Under
+	// on-failure, services that exit cleanly are not replaced.
+	Policy string `json:"policy"`
+	// MaxRetries limits the replacements made under on-failure, unlimited if
+	// zero
+	MaxRetries int `json:"max_retries"`
+	// BackoffSeconds is the delay before the first replacement, doubling
+	// with every failure after, defaulting to 10
+	BackoffSeconds int `json:"backoff_seconds"`
+	// MaxBackoffSeconds caps the delay, defaulting to 300
+	MaxBackoffSeconds int `json:"max_backoff_seconds"`
+}
+
+// Backoff returns how long to wait before replacing a service after the
+// given number of failures in a row.
+func (r RestartPolicy) Backoff(failures int) time.Duration {
+	backoff := time.Duration(r.BackoffSeconds) * time.Second
+	if backoff == 0 {
+		backoff = 10 * time.Second
+	}
+	max := time.Duration(r.MaxBackoffSeconds) * time.Second
+	if max == 0 {
+		max = 300 * time.Second
+	}
+
+	for i := 1; i < failures && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+
+	return backoff
+}
+
+const (
+	ROLLING   = "rolling"
+	CANARY    = "canary"
+	BLUEGREEN = "bluegreen"
+)
+
+// UpdateStrategy controls how services are replaced when a project's
+// configuration changes.
+type UpdateStrategy struct {
+	// Type is one of rolling (the default), canary or bluegreen. Canary and
+	// blue/green deployments are finished with a promote or abort.
+	Type string `json:"type"`
+
+	// MaxSurge is how many services above count may run during an update
+	MaxSurge int `json:"max_surge"`
+	// MaxUnavailable is how many services below count may be available
+	// during an update
+	MaxUnavailable int `json:"max_unavailable"`
+	// MinHealthySeconds is how long a new service must be running before it
+	// counts as available
+	MinHealthySeconds int `json:"min_healthy_seconds"`
+
+	// CanaryCount is how many services of the new revision a canary
+	// deployment runs, defaulting to one
+	CanaryCount int `json:"canary_count"`
+	// CanaryWeight is the percentage of traffic sent to the canary, from 1
+	// to 99 for canary deployments
+	CanaryWeight int `json:"canary_weight"`
+	// ScaleDownDelaySeconds is how long the previous services of a promoted
+	// blue/green deployment are kept for. If zero, they are kept until the
+	// next deployment.
+	ScaleDownDelaySeconds int `json:"scale_down_delay_seconds"`
+}
+
+// Limits returns the surge and unavailable limits of the strategy. If neither
+// is set, one service is surged at a time.
+func (u UpdateStrategy) Limits() (maxSurge int, maxUnavailable int) {
+	if u.MaxSurge == 0 && u.MaxUnavailable == 0 {
+		return 1, 0
+	}
+
+	return u.MaxSurge, u.MaxUnavailable
+}
+
+// Placement constrains the nodes a project's services can be scheduled on,
+// using node labels.
+type Placement struct {
+	// Required labels must all be present on a node
+	Required []string `json:"required"`
+	// Excluded labels must not be present on a node
+	Excluded []string `json:"excluded"`
+	// Preferred labels add their weight to a node's score, and the highest
+	// scoring nodes are picked first
+	Preferred []PreferredLabel `json:"preferred"`
+
+	// Strategy overrides the scheduling strategy set in metis.scheduler.strategy
+	Strategy string `json:"strategy"`
+	// SpreadLabel is the key=value label key the spread strategy spreads
+	// services across, overriding metis.scheduler.spread_label
+	SpreadLabel string `json:"spread_label"`
+}
+
+type PreferredLabel struct {
+	Label  string `json:"label"`
+	Weight int    `json:"weight"`
+}
+
+var validPortName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// validProjectName matches the names docker allows for containers, which are
+// named after their project.
+var validProjectName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Validate checks that the project can be scheduled.
+func (p Project) Validate() error {
+	if p.Name == "" {
+		return errors.New("project name is required")
+	}
+	if !validProjectName.MatchString(p.Name) {
+		return fmt.Errorf("invalid project name %q", p.Name)
+	}
+	if p.Configuration.ImageName == "" {
+		return errors.New("image is required")
+	}
+	if p.Configuration.Count < 0 {
+		return errors.New("count cannot be negative")
+	}
+	if len(p.Configuration.Ports) == 0 && p.Configuration.ContainerPort <= 0 {
+		return errors.New("container_port is required")
+	}
+	if len(p.Configuration.Ports) > 0 && (p.Configuration.ContainerPort != 0 || p.Configuration.Host != "" ||
+		!p.Configuration.HTTPRoute.IsZero()) {
+		return errors.New("use either container_port and its http route, or ports")
+	}
+	if p.Configuration.Host != "" && !validHost(p.Configuration.Host) {
+		return fmt.Errorf("invalid host %q", p.Configuration.Host)
+	}
+	if err := p.Configuration.HTTPRoute.Validate(); err != nil {
+		return err
+	}
+	portNames := map[string]bool{}
+	for _, port := range p.Configuration.Ports {
+		if !validPortName.MatchString(port.Name) {
+			return fmt.Errorf("port name %q must be lowercase letters, digits and dashes", port.Name)
+		}
+		if portNames[port.Name] {
+			return fmt.Errorf("port name %q is used more than once", port.Name)
+		}
+		portNames[port.Name] = true
+		if err := port.Validate(); err != nil {
+			return fmt.Errorf("port %q: %w", port.Name, err)
+		}
+	}
+	for key := range p.Configuration.Env {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+	targets := map[string]bool{}
+	for _, mount := range p.Configuration.Mounts {
+		if err := mount.Validate(); err != nil {
+			return fmt.Errorf("mount %q: %w", mount.Target, err)
+		}
+		if targets[path.Clean(mount.Target)] {
+			return fmt.Errorf("mount target %q is used more than once", mount.Target)
+		}
+		targets[path.Clean(mount.Target)] = true
+	}
+	for _, secret := range p.Configuration.Secrets {
+		if err := secret.Validate(); err != nil {
+			return fmt.Errorf("secret %q: %w", secret.Name, err)
+		}
+	}
+	strategy := p.Configuration.UpdateStrategy
+	if strategy.MaxSurge < 0 || strategy.MaxUnavailable < 0 || strategy.MinHealthySeconds < 0 ||
+		strategy.CanaryCount < 0 || strategy.ScaleDownDelaySeconds < 0 {
+		return errors.New("update_strategy cannot be negative")
+	}
+	switch strategy.Type {
+	case "", ROLLING, CANARY, BLUEGREEN:
+	default:
+		return fmt.Errorf("unknown update strategy %q", strategy.Type)
+	}
+	if strategy.CanaryWeight < 0 || strategy.CanaryWeight > 100 {
+		return errors.New("canary_weight must be between 0 and 100")
+	}
+	if strategy.Type == CANARY && (strategy.CanaryWeight == 0 || strategy.CanaryWeight == 100) {
+		// A canary without traffic, or with all of it, tests nothing
+		return errors.New("canary_weight of a canary deployment must be between 1 and 99")
+	}
+	if p.Configuration.LivenessProbe != nil {
+		if err := p.Configuration.LivenessProbe.Validate(); err != nil {
+			return fmt.Errorf("liveness_probe: %w", err)
+		}
+	}
+	if p.Configuration.ReadinessProbe != nil {
+		if err := p.Configuration.ReadinessProbe.Validate(); err != nil {
+			return fmt.Errorf("readiness_probe: %w", err)
+		}
+	}
+	restart := p.Configuration.RestartPolicy
+	switch restart.Policy {
+	case "", ALWAYS, ONFAILURE, NEVER:
+	default:
+		return fmt.Errorf("unknown restart policy %q", restart.Policy)
+	}
+	if restart.MaxRetries < 0 || restart.BackoffSeconds < 0 || restart.MaxBackoffSeconds < 0 {
+		return errors.New("restart_policy cannot be negative")
+	}
+	resources := p.Configuration.Resources
+	if resources.Requests.CPU < 0 || resources.Requests.Memory < 0 ||
+		resources.Limits.CPU < 0 || resources.Limits.Memory < 0 {
+		return errors.New("resources cannot be negative")
+	}
+	if !resources.Requests.Fits(resources.Limits) {
+		return errors.New("resource requests cannot exceed limits")
+	}
+	for _, label := range p.Configuration.RequiredLabels() {
+		if p.Configuration.Placement.Excludes(label) {
+			return fmt.Errorf("label %q is both required and excluded", label)
+		}
+	}
+
+	return nil
+}
+
+// RequiredLabels returns the labels a node needs to run the project: those
+// required by its placement and those of its node-local mounts.
+func (c ProjectConfiguration) RequiredLabels() []string {
+	labels := append([]string{}, c.Placement.Required...)
+	for _, mount := range c.Mounts {
+		if mount.Label != "" {
+			labels = append(labels, mount.Label)
+		}
+	}
+
+	return labels
+}
+
+// Excludes reports whether the label is excluded by the placement.
+func (p Placement) Excludes(label string) bool {
+	for _, excluded := range p.Excluded {
+		if excluded == label {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Revision is a configuration a project has had, kept so it can be rolled
+// back to.
+type Revision struct {
+	Revision      int                  `json:"revision"`
+	Configuration ProjectConfiguration `json:"configuration"`
+	CreatedAt     time.Time            `json:"created_at"`
+	Reason        string               `json:"reason"`
+}
+
+// Deployment tracks a canary or blue/green deployment of a project. Stable is
+// the revision receiving traffic and Candidate the revision being deployed.
+// Once a blue/green deployment is promoted, the revision it replaced is kept
+// as Previous so that the switch can be reverted.
+type Deployment struct {
+	Strategy              string               `json:"strategy"`
+	Stable                int                  `json:"stable"`
+	StableConfiguration   ProjectConfiguration `json:"stable_configuration"`
+	Candidate             int                  `json:"candidate"`
+	Previous              int                  `json:"previous"`
+	PreviousConfiguration ProjectConfiguration `json:"previous_configuration"`
+	PromotedAt            time.Time            `json:"promoted_at"`
+}
+
+func (d Deployment) Promoted() bool {
+	return !d.PromotedAt.IsZero()
 }
@@ -0,0 +1,138 @@
+package project
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RestartPolicy
+		failures int
+		want     time.Duration
+	}{
+		{name: "default first failure", failures: 1, want: 10 * time.Second},
+		{name: "no failures", failures: 0, want: 10 * time.Second},
+		{name: "doubles with each failure", failures: 3, want: 40 * time.Second},
+		{name: "default cap", failures: 10, want: 300 * time.Second},
+		{name: "custom backoff", policy: RestartPolicy{BackoffSeconds: 2}, failures: 2, want: 4 * time.Second},
+		{
+			name:     "custom cap",
+			policy:   RestartPolicy{BackoffSeconds: 5, MaxBackoffSeconds: 30},
+			failures: 4,
+			want:     30 * time.Second,
+		},
+		{
+			name:     "backoff above the cap",
+			policy:   RestartPolicy{BackoffSeconds: 60, MaxBackoffSeconds: 30},
+			failures: 1,
+			want:     30 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Backoff(test.failures); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPortValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		port  Port
+		valid bool
+	}{
+		{name: "http with a host", port: Port{Name: "web", ContainerPort: 80, Host: "example.com"}, valid: true},
+		{name: "http without matchers", port: Port{Name: "web", ContainerPort: 80}},
+		{name: "host with a backtick", port: Port{Name: "web", ContainerPort: 80, Host: "a`) || Host(`b"}},
+		{name: "host with a space", port: Port{Name: "web", ContainerPort: 80, Host: "a b"}},
+		{
+			name:  "tcp with sni",
+			port:  Port{Name: "db", ContainerPort: 5432, Protocol: "tcp", SNI: "db.example.com", EntryPoints: []string{"db"}},
+			valid: true,
+		},
+		{name: "tcp without entrypoints", port: Port{Name: "db", ContainerPort: 5432, Protocol: "tcp"}},
+		{name: "tcp with a host", port: Port{Name: "db", ContainerPort: 5432, Protocol: "tcp", Host: "example.com", EntryPoints: []string{"db"}}},
+		{name: "missing container port", port: Port{Name: "web", Host: "example.com"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.port.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestProjectValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "web", valid: true},
+		{name: "web_2.blue-green", valid: true},
+		{name: "9lives", valid: true},
+		{name: ""},
+		{name: "my app"},
+		{name: "-web"},
+		{name: "web/api"},
+	}
+
+	for _, test := range tests {
+		proj := Project{
+			Name:          test.name,
+			Configuration: ProjectConfiguration{ImageName: "nginx", ContainerPort: 80, Host: "example.com"},
+		}
+		err := proj.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%q: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%q: expected an error", test.name)
+		}
+	}
+}
+
+func TestUpdateStrategyValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy UpdateStrategy
+		valid    bool
+	}{
+		{name: "rolling", strategy: UpdateStrategy{}, valid: true},
+		{name: "canary", strategy: UpdateStrategy{Type: CANARY, CanaryWeight: 10}, valid: true},
+		{name: "canary without weight", strategy: UpdateStrategy{Type: CANARY}},
+		{name: "canary with all traffic", strategy: UpdateStrategy{Type: CANARY, CanaryWeight: 100}},
+		{name: "weight over 100", strategy: UpdateStrategy{CanaryWeight: 101}},
+		{name: "negative surge", strategy: UpdateStrategy{MaxSurge: -1}},
+		{name: "unknown type", strategy: UpdateStrategy{Type: "recreate"}},
+	}
+
+	for _, test := range tests {
+		proj := Project{
+			Name: "web",
+			Configuration: ProjectConfiguration{
+				ImageName:      "nginx",
+				ContainerPort:  80,
+				Host:           "example.com",
+				UpdateStrategy: test.strategy,
+			},
+		}
+		err := proj.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// HTTPRoute matches the HTTP requests routed to a port, and how they are
+// served. A request must match one of the hosts, one of the path prefixes,
+// every header and one of the methods, with any of these left empty matching
+// all requests.
+type HTTPRoute struct {
+	Hosts        []string          `json:"hosts"`
+	PathPrefixes []string          `json:"path_prefixes"`
+	Headers      map[string]string `json:"headers"`
+	Methods      []string          `json:"methods"`
+	// Priority orders routes matching the same request, highest first.
+	// Defaults to the length of the route's rule, so that longer paths win.
+	Priority int `json:"priority"`
+
+	TLS *TLS `json:"tls"`
+	// Middlewares are applied to requests in order
+	Middlewares []Middleware `json:"middlewares"`
+
+	LoadBalancer *LoadBalancer `json:"load_balancer"`
+}
+
+// LoadBalancer configures how requests are balanced between a project's
+// services.
+type LoadBalancer struct {
+	// HealthCheck has the load balancer stop sending requests to services
+	// failing the check until the next update removes them
+	HealthCheck *HealthCheck `json:"health_check"`
+	// Sticky keeps clients on the same service with a cookie
+	Sticky *Sticky `json:"sticky"`
+	// PassHostHeader forwards the Host header of the request to the service,
+	// defaulting to true
+	PassHostHeader *bool `json:"pass_host_header"`
+}
+
+// HealthCheck is an HTTP request made by the load balancer to each service,
+// which passes with a 2xx or 3xx status.
+type HealthCheck struct {
+	Path string `json:"path"`
+	// IntervalSeconds and TimeoutSeconds default to those of the load
+	// balancer
+	IntervalSeconds int `json:"interval_seconds"`
+	TimeoutSeconds  int `json:"timeout_seconds"`
+}
+
+type Sticky struct {
+	// CookieName defaults to one generated by the load balancer
+	CookieName string `json:"cookie_name"`
+	Secure     bool   `json:"secure"`
+	HTTPOnly   bool   `json:"http_only"`
+}
+
+func (l LoadBalancer) Validate() error {
+	if l.HealthCheck != nil {
+		if !strings.HasPrefix(l.HealthCheck.Path, "/") {
+			return errors.New("health_check needs an absolute path")
+		}
+		if l.HealthCheck.IntervalSeconds < 0 || l.HealthCheck.TimeoutSeconds < 0 {
+			return errors.New("health_check cannot be negative")
+		}
+	}
+
+	return nil
+}
+
+var httpMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "CONNECT": true, "OPTIONS": true, "TRACE": true,
+}
+
+func (r HTTPRoute) IsZero() bool {
+	return len(r.Hosts) == 0 && len(r.PathPrefixes) == 0 && len(r.Headers) == 0 &&
+		len(r.Methods) == 0 && r.Priority == 0 && r.TLS == nil && len(r.Middlewares) == 0 &&
+		r.LoadBalancer == nil
+}
+
+// validHost reports whether a host can be used in a routing rule.
+func validHost(host string) bool {
+	return host != "" && !strings.ContainsAny(host, "`/") && !hasSpaceOrControl(host)
+}
+
+var validHeaderName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// hasSpaceOrControl reports whether s has whitespace or control characters,
+// which would split or escape the values written to router configuration
+// files.
+func hasSpaceOrControl(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) >= 0
+}
+
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
+
+func (r HTTPRoute) Validate() error {
+	for _, host := range r.Hosts {
+		if !validHost(host) {
+			return fmt.Errorf("invalid host %q", host)
+		}
+	}
+	for _, prefix := range r.PathPrefixes {
+		if !strings.HasPrefix(prefix, "/") || strings.Contains(prefix, "`") || hasSpaceOrControl(prefix) {
+			return fmt.Errorf("invalid path prefix %q", prefix)
+		}
+	}
+	for key, value := range r.Headers {
+		if !validHeaderName.MatchString(key) || strings.Contains(value, "`") || hasControl(value) {
+			return fmt.Errorf("invalid header %q", key)
+		}
+	}
+	for _, method := range r.Methods {
+		if !httpMethods[method] {
+			return fmt.Errorf("invalid method %q", method)
+		}
+	}
+	if r.Priority < 0 {
+		return errors.New("priority cannot be negative")
+	}
+	if r.TLS != nil {
+		if err := r.TLS.Validate(); err != nil {
+			return err
+		}
+	}
+	for i, middleware := range r.Middlewares {
+		if err := middleware.Validate(); err != nil {
+			return fmt.Errorf("middleware %d: %w", i, err)
+		}
+	}
+	if r.LoadBalancer != nil {
+		if err := r.LoadBalancer.Validate(); err != nil {
+			return fmt.Errorf("load_balancer: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package project
+
+import "testing"
+
+func TestHTTPRouteValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		route HTTPRoute
+		valid bool
+	}{
+		{
+			name: "every matcher",
+			route: HTTPRoute{
+				Hosts:        []string{"example.com"},
+				PathPrefixes: []string{"/api"},
+				Headers:      map[string]string{"Authorization": "Bearer token"},
+				Methods:      []string{"GET"},
+			},
+			valid: true,
+		},
+		{name: "host with a newline", route: HTTPRoute{Hosts: []string{"example.com\nbackend evil"}}},
+		{name: "host with a tab", route: HTTPRoute{Hosts: []string{"example.com\tevil"}}},
+		{name: "host with a backtick", route: HTTPRoute{Hosts: []string{"a`b"}}},
+		{name: "relative path prefix", route: HTTPRoute{PathPrefixes: []string{"api"}}},
+		{name: "path prefix with a space", route: HTTPRoute{PathPrefixes: []string{"/a b"}}},
+		{name: "path prefix with a newline", route: HTTPRoute{PathPrefixes: []string{"/a\nb"}}},
+		{name: "header value with a newline", route: HTTPRoute{Headers: map[string]string{"X-Test": "a\nb"}}},
+		{name: "header name with a colon", route: HTTPRoute{Headers: map[string]string{"X:Test": "a"}}},
+		{name: "header name with a parenthesis", route: HTTPRoute{Headers: map[string]string{"X)": "a"}}},
+		{name: "unknown method", route: HTTPRoute{Methods: []string{"BREW"}}},
+		{name: "negative priority", route: HTTPRoute{Priority: -1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.route.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -1,26 +1,50 @@
 package provider
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"math/rand"
+	"metis/pkg/probe"
 	"metis/pkg/service"
 	"metis/pkg/state"
 	"metis/pkg/status"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Strum355/log"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+// Labels of the containers created by the agent, which let it find the
+// containers it manages after a restart and the controller rebuild its state.
+const (
+	managedLabel    = "metis.managed"
+	projectLabel    = "metis.project"
+	revisionLabel   = "metis.revision"
+	instanceLabel   = "metis.instance"
+	controllerLabel = "metis.controller"
+	specLabel       = "metis.spec"
 )
 
 type DockerProvider struct {
 	client *client.Client
-	state  map[string]state.ServiceState
+	probes *probe.Manager
 }
 
 func NewDockerProvider() (DockerProvider, error) {
@@ -28,7 +52,10 @@ func NewDockerProvider() (DockerProvider, error) {
 	if err != nil {
 		return DockerProvider{}, err
 	}
-	return DockerProvider{client: cli, state: make(map[string]state.ServiceState)}, nil
+	return DockerProvider{
+		client: cli,
+		probes: probe.NewManager(),
+	}, nil
 }
 
 func (d *DockerProvider) GetContainers() ([]string, error) {
@@ -62,46 +89,80 @@ func (d *DockerProvider) CreateService(ctx context.Context, srvc service.Service
 	// }
 	// defer out.Close()
 
-	name := fmt.Sprintf("%s-%s", srv.SrvName, uuid.NewString()[:8])
-	rand.Seed(time.Now().Unix())
-	port := rand.Int31n(2000) + 4000
+	vars, files, err := secrets(srv)
+	if err != nil {
+		return state.ServiceState{}, err
+	}
+	// Secret values are not kept once they are in the container
+	srv.SecretValues = nil
+
+	mounts, err := d.mounts(ctx, srv.Mounts)
+	if err != nil {
+		return state.ServiceState{}, err
+	}
+
+	instance := uuid.NewString()[:8]
+	name := fmt.Sprintf("%s-%s", srv.SrvName, instance)
+	exposed, bindings, nodePorts := ports(srv.PublishedPorts())
 	resp, err := d.client.ContainerCreate(ctx, &container.Config{
 		Image:        srv.DockerImage,
-		ExposedPorts: nat.PortSet{nat.Port(fmt.Sprintf("%d/tcp", srv.ContainerPort)): struct{}{}},
-	}, &container.HostConfig{
-		PortBindings: nat.PortMap{
-			nat.Port(fmt.Sprintf("%d/tcp", srv.ContainerPort)): []nat.PortBinding{
-				{
-					HostIP:   "0.0.0.0",
-					HostPort: fmt.Sprintf("%d", port),
-				},
-			},
+		ExposedPorts: exposed,
+		Env:          env(vars),
+		Entrypoint:   strslice.StrSlice(srv.Entrypoint),
+		Cmd:          command(srv),
+		WorkingDir:   srv.WorkingDir,
+		User:         srv.User,
+		Labels: map[string]string{
+			managedLabel:    "true",
+			projectLabel:    srv.SrvName,
+			revisionLabel:   strconv.Itoa(srv.Revision),
+			instanceLabel:   instance,
+			controllerLabel: srv.Controller,
+			specLabel:       srv.Spec(),
 		},
+	}, &container.HostConfig{
+		Resources:    resources(srv.Resources),
+		Mounts:       mounts,
+		PortBindings: bindings,
 	}, nil, nil, name)
 	if err != nil {
-		panic(err)
+		return state.ServiceState{}, err
 	}
 
-	state := state.ServiceState{
-		Status:      status.CREATED,
-		Service:     srv,
-		Name:        name,
-		ID:          resp.ID,
-		ExposedPort: port,
+	if len(files) > 0 {
+		err = d.copyFiles(ctx, resp.ID, files)
+		if err != nil {
+			_ = d.client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{})
+			return state.ServiceState{}, err
+		}
 	}
-	d.state[srv.SrvName] = state
 
-	return state, nil
+	return state.ServiceState{
+		Status:       status.CREATED,
+		Service:      srv,
+		Name:         name,
+		ID:           resp.ID,
+		ExposedPort:  nodePorts[srv.PublishedPorts()[0].Name],
+		ExposedPorts: nodePorts,
+	}, nil
 }
 
 func (d *DockerProvider) StartService(ctx context.Context, srv state.ServiceState) (state.ServiceState, error) {
 	if err := d.client.ContainerStart(ctx, srv.ID, types.ContainerStartOptions{}); err != nil {
 		return state.ServiceState{}, err
 	}
+
+	err := d.startProbes(ctx, srv)
+	if err != nil {
+		log.WithError(err).Error("Could not start probes for service")
+	}
+
 	return srv, nil
 }
 
 func (d *DockerProvider) StopService(ctx context.Context, srv state.ServiceState) (state.ServiceState, error) {
+	d.probes.Stop(srv.ID)
+
 	timeout := 20 * time.Second
 	if err := d.client.ContainerStop(ctx, srv.ID, &timeout); err != nil {
 		return state.ServiceState{}, err
@@ -112,11 +173,12 @@ func (d *DockerProvider) StopService(ctx context.Context, srv state.ServiceState
 }
 
 func (d *DockerProvider) DestroyService(ctx context.Context, srv state.ServiceState) (state.ServiceState, error) {
+	d.probes.Stop(srv.ID)
+
 	if err := d.client.ContainerRemove(ctx, srv.ID, types.ContainerRemoveOptions{}); err != nil {
 		return state.ServiceState{}, err
 	}
 	srv.Status = status.STOPPED
-	delete(d.state, srv.Service.Name())
 	return srv, nil
 }
 
@@ -132,21 +194,151 @@ func (d *DockerProvider) ServiceHealth(ctx context.Context, srv state.ServiceSta
 		return srv, nil
 	}
 
-	if cnt_json.State.Running && srv.Status != status.RUNNING {
-		log.WithFields(log.Fields{
-			"old_status": srv.Status,
-			"new_status": status.RUNNING,
-		}).Info("Service health updated")
-		srv.Status = status.RUNNING
-	} else if !cnt_json.State.Running && srv.Status == status.RUNNING {
+	if cnt_json.State.Running {
+		if _, _, ok := d.probes.Status(srv.ID); !ok {
+			// The agent has restarted since the service was started
+			err = d.startProbes(ctx, srv)
+			if err != nil {
+				log.WithError(err).Error("Could not start probes for service")
+			}
+		}
+	}
+
+	updated := d.containerStatus(cnt_json).Apply(srv)
+	if updated.Status != srv.Status {
 		log.WithFields(log.Fields{
 			"old_status": srv.Status,
-			"new_status": status.UNHEALTHY,
+			"new_status": updated.Status,
+			"exit_code":  updated.ExitCode,
 		}).Info("Service health updated")
-		srv.Status = status.UNHEALTHY
 	}
 
-	return srv, nil
+	return updated, nil
+}
+
+// AdoptService takes over the container of a service the controller has
+// rebuilt from the agent's containers, starting its probes.
+func (d *DockerProvider) AdoptService(ctx context.Context, srv state.ServiceState) (state.ServiceState, error) {
+	cnt_json, err := d.client.ContainerInspect(ctx, srv.ID)
+	if err != nil {
+		return srv, err
+	}
+	if cnt_json.Config.Labels[managedLabel] != "true" {
+		return srv, fmt.Errorf("container %s is not managed by metis", srv.ID)
+	}
+
+	return d.ServiceHealth(ctx, srv)
+}
+
+// ServiceStatuses returns the status of every container managed by Metis on
+// the node. Probes are not started for containers that have none running,
+// as the agent does not know their services.
+func (d *DockerProvider) ServiceStatuses(ctx context.Context) ([]state.ContainerStatus, error) {
+	containers, err := d.client.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", managedLabel+"=true")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []state.ContainerStatus{}
+	for _, cont := range containers {
+		cnt_json, err := d.client.ContainerInspect(ctx, cont.ID)
+		if err != nil {
+			// The container was removed since it was listed
+			continue
+		}
+		statuses = append(statuses, d.containerStatus(cnt_json))
+	}
+
+	return statuses, nil
+}
+
+// containerStatus combines the state of a container with its probe results.
+func (d *DockerProvider) containerStatus(cnt_json types.ContainerJSON) state.ContainerStatus {
+	live, ready, probed := d.probes.Status(cnt_json.ID)
+	labels := cnt_json.Config.Labels
+	revision, _ := strconv.Atoi(labels[revisionLabel])
+
+	ports := map[string]int32{}
+	for port, bindings := range cnt_json.HostConfig.PortBindings {
+		for _, binding := range bindings {
+			nodePort, err := strconv.ParseInt(binding.HostPort, 10, 32)
+			if err == nil {
+				ports[string(port)] = int32(nodePort)
+				break
+			}
+		}
+	}
+
+	return state.ContainerStatus{
+		ID:         cnt_json.ID,
+		Name:       strings.TrimPrefix(cnt_json.Name, "/"),
+		Project:    labels[projectLabel],
+		Revision:   revision,
+		Instance:   labels[instanceLabel],
+		Controller: labels[controllerLabel],
+		Spec:       labels[specLabel],
+		Image:      cnt_json.Config.Image,
+		Ports:      ports,
+		State:      cnt_json.State.Status,
+		ExitCode:   cnt_json.State.ExitCode,
+		Probed:     probed,
+		Live:       live,
+		Ready:      ready,
+	}
+}
+
+// startProbes starts running the liveness and readiness probes of a service
+// against its container.
+func (d *DockerProvider) startProbes(ctx context.Context, srv state.ServiceState) error {
+	address, err := d.GetServiceAddress(ctx, srv)
+	if err != nil {
+		return err
+	}
+	if address == "" {
+		// Containers on the host network have no address of their own
+		address = "127.0.0.1"
+	}
+
+	d.probes.Start(srv.ID, srv.Service, probe.Target{
+		Address: address,
+		Exec: func(ctx context.Context, cmd []string) (int, error) {
+			return d.exec(ctx, srv.ID, cmd)
+		},
+	})
+
+	return nil
+}
+
+// exec runs a command in a container and waits for its exit code.
+func (d *DockerProvider) exec(ctx context.Context, id string, cmd []string) (int, error) {
+	exec, err := d.client.ContainerExecCreate(ctx, id, types.ExecConfig{Cmd: cmd})
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.client.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{Detach: true})
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		inspect, err := d.client.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return 0, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
 }
 
 func (d *DockerProvider) GetServiceAddress(ctx context.Context, srv state.ServiceState) (string, error) {
@@ -157,3 +349,215 @@ func (d *DockerProvider) GetServiceAddress(ctx context.Context, srv state.Servic
 
 	return cnt_json.NetworkSettings.IPAddress, nil
 }
+
+// Capacity returns the CPUs and memory available to the docker daemon.
+func (d *DockerProvider) Capacity(ctx context.Context) (service.Resources, error) {
+	info, err := d.client.Info(ctx)
+	if err != nil {
+		return service.Resources{}, err
+	}
+
+	return service.Resources{
+		CPU:    float64(info.NCPU),
+		Memory: info.MemTotal / megabyte,
+	}, nil
+}
+
+const megabyte = 1024 * 1024
+
+// resources converts resource requirements to docker container resources.
+// Requests are applied as CPU shares and a memory reservation, and limits as
+// hard limits.
+func resources(req service.ResourceRequirements) container.Resources {
+	return container.Resources{
+		CPUShares:         int64(req.Requests.CPU * 1024),
+		MemoryReservation: req.Requests.Memory * megabyte,
+		NanoCPUs:          int64(req.Limits.CPU * 1e9),
+		Memory:            req.Limits.Memory * megabyte,
+	}
+}
+
+// env converts environment variables to KEY=value pairs, sorted so that the
+// container config is stable.
+func env(vars map[string]string) []string {
+	pairs := []string{}
+	for key, value := range vars {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return pairs
+}
+
+// ports picks a random node port for each port of a service, returning the
+// container's exposed ports and port bindings, and the node ports keyed by
+// port name.
+func ports(srvPorts []service.Port) (nat.PortSet, nat.PortMap, map[string]int32) {
+	rand.Seed(time.Now().UnixNano())
+
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+	nodePorts := map[string]int32{}
+	used := map[int32]bool{}
+	for _, p := range srvPorts {
+		nodePort := rand.Int31n(2000) + 4000
+		for used[nodePort] {
+			nodePort = rand.Int31n(2000) + 4000
+		}
+		used[nodePort] = true
+
+		protocol := service.TCP
+		if p.Protocol == service.UDP {
+			protocol = service.UDP
+		}
+		containerPort := nat.Port(fmt.Sprintf("%d/%s", p.ContainerPort, protocol))
+		exposed[containerPort] = struct{}{}
+		bindings[containerPort] = append(bindings[containerPort], nat.PortBinding{
+			HostIP:   "0.0.0.0",
+			HostPort: fmt.Sprintf("%d", nodePort),
+		})
+		nodePorts[p.Name] = nodePort
+	}
+
+	return exposed, bindings, nodePorts
+}
+
+// mounts converts the mounts of a service to docker mounts, creating any
+// named volumes that do not exist yet. Bind mounts are only allowed from the
+// host paths in metis.agent.bind_allowlist.
+func (d *DockerProvider) mounts(ctx context.Context, mnts []service.Mount) ([]mount.Mount, error) {
+	converted := []mount.Mount{}
+	for _, mnt := range mnts {
+		m := mount.Mount{
+			Source:   mnt.Source,
+			Target:   mnt.Target,
+			ReadOnly: mnt.ReadOnly,
+		}
+
+		switch mnt.Type {
+		case service.VOLUME:
+			m.Type = mount.TypeVolume
+			_, err := d.client.VolumeCreate(ctx, volume.VolumeCreateBody{Name: mnt.Source})
+			if err != nil {
+				return nil, err
+			}
+		case service.BIND:
+			m.Type = mount.TypeBind
+			if !bindAllowed(mnt.Source) {
+				return nil, fmt.Errorf("host path %q is not in the bind mount allowlist", mnt.Source)
+			}
+		case service.TMPFS:
+			m.Type = mount.TypeTmpfs
+			m.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: mnt.SizeMB * megabyte}
+		default:
+			return nil, fmt.Errorf("unknown mount type %q", mnt.Type)
+		}
+
+		converted = append(converted, m)
+	}
+
+	return converted, nil
+}
+
+// bindAllowed reports whether a host path is, or is inside, one of the
+// comma separated paths in metis.agent.bind_allowlist.
+func bindAllowed(source string) bool {
+	source = filepath.Clean(source)
+	for _, allowed := range strings.Split(viper.GetString("metis.agent.bind_allowlist"), ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "" {
+			continue
+		}
+		allowed = filepath.Clean(allowed)
+		if source == allowed || strings.HasPrefix(source, strings.TrimSuffix(allowed, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// secrets returns the environment variables of a service with its env
+// secrets added, and the contents of its file secrets keyed by path.
+func secrets(srv service.DockerService) (map[string]string, map[string]string, error) {
+	vars := map[string]string{}
+	for key, value := range srv.Env {
+		vars[key] = value
+	}
+
+	files := map[string]string{}
+	for _, ref := range srv.Secrets {
+		value, ok := srv.SecretValues[ref.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("no value sent for secret %q", ref.Name)
+		}
+		if ref.Env != "" {
+			vars[ref.Env] = value
+		} else {
+			files[ref.File] = value
+		}
+	}
+
+	return vars, files, nil
+}
+
+// copyFiles writes files into a created container, creating any missing
+// parent directories.
+func (d *DockerProvider) copyFiles(ctx context.Context, id string, files map[string]string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	dirs := map[string]bool{}
+	for file, content := range files {
+		parents := []string{}
+		for dir := path.Dir(file); dir != "/"; dir = path.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+		}
+		for _, dir := range parents {
+			if dirs[dir] {
+				continue
+			}
+			dirs[dir] = true
+			err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     strings.TrimPrefix(dir, "/") + "/",
+				Mode:     0755,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(file, "/"),
+			Mode:     0444,
+			Size:     int64(len(content)),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write([]byte(content))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return d.client.CopyToContainer(ctx, id, "/", &buf, types.CopyToContainerOptions{})
+}
+
+// command returns the CMD of the container: the service's command followed by
+// its args, or nil to keep the image's CMD.
+func command(srv service.DockerService) strslice.StrSlice {
+	if len(srv.Command) == 0 && len(srv.Args) == 0 {
+		return nil
+	}
+
+	cmd := append([]string{}, srv.Command...)
+	return strslice.StrSlice(append(cmd, srv.Args...))
+}
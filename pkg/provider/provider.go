@@ -12,5 +12,8 @@ type Provider interface {
 	StopService(context.Context, state.ServiceState) (state.ServiceState, error)
 	DestroyService(context.Context, state.ServiceState) (state.ServiceState, error)
 	ServiceHealth(ctx context.Context, srv state.ServiceState) (state.ServiceState, error)
+	ServiceStatuses(ctx context.Context) ([]state.ContainerStatus, error)
+	AdoptService(ctx context.Context, srv state.ServiceState) (state.ServiceState, error)
 	GetServiceAddress(ctx context.Context, srv state.ServiceState) (string, error)
+	Capacity(ctx context.Context) (service.Resources, error)
 }
@@ -0,0 +1,143 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// CaddyBackend renders the HTTP routes of the routing table as Caddy JSON
+// configuration, which is loaded through Caddy's admin API if
+// metis.routing.caddy.admin_url is set. TLS, middlewares and load balancer
+// settings are only supported by the Traefik backend.
+type CaddyBackend struct {
+	listen   string
+	adminURL string
+}
+
+func NewCaddyBackend() CaddyBackend {
+	return CaddyBackend{
+		listen:   viper.GetString("metis.routing.caddy.listen"),
+		adminURL: viper.GetString("metis.routing.caddy.admin_url"),
+	}
+}
+
+func (CaddyBackend) Name() string {
+	return CADDY
+}
+
+func (CaddyBackend) ContentType() string {
+	return "application/json"
+}
+
+func (b CaddyBackend) Render(table Table) ([]byte, error) {
+	routes := []map[string]interface{}{}
+	for _, route := range table.HTTPRoutes() {
+		routes = append(routes, map[string]interface{}{
+			"match":    []map[string]interface{}{caddyMatcher(route)},
+			"handle":   []map[string]interface{}{caddyHandler(route)},
+			"terminal": true,
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"apps": map[string]interface{}{
+			"http": map[string]interface{}{
+				"servers": map[string]interface{}{
+					"metis": map[string]interface{}{
+						"listen":          []string{b.listen},
+						"routes":          routes,
+						"automatic_https": map[string]interface{}{"disable": true},
+					},
+				},
+			},
+		},
+	})
+}
+
+// Push loads the configuration into Caddy through its admin API.
+func (b CaddyBackend) Push(ctx context.Context, config []byte) error {
+	if b.adminURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(b.adminURL, "/")+"/load", bytes.NewReader(config))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("caddy admin API returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func caddyMatcher(route Route) map[string]interface{} {
+	matcher := map[string]interface{}{}
+	if hosts := route.Port.AllHosts(); len(hosts) > 0 {
+		matcher["host"] = hosts
+	}
+	if len(route.Port.PathPrefixes) > 0 {
+		paths := []string{}
+		for _, prefix := range route.Port.PathPrefixes {
+			paths = append(paths, prefix+"*")
+		}
+		matcher["path"] = paths
+	}
+	if len(route.Port.Headers) > 0 {
+		headers := map[string][]string{}
+		for key, value := range route.Port.Headers {
+			headers[key] = []string{value}
+		}
+		matcher["header"] = headers
+	}
+	if len(route.Port.Methods) > 0 {
+		matcher["method"] = route.Port.Methods
+	}
+
+	return matcher
+}
+
+// caddyHandler proxies to the route's servers, weighting each server by its
+// revision's share of traffic, or responds with a 503 if there are none.
+func caddyHandler(route Route) map[string]interface{} {
+	servers := route.WeightedServers(1000)
+	if len(servers) == 0 {
+		return map[string]interface{}{"handler": "static_response", "status_code": 503}
+	}
+
+	upstreams := []map[string]string{}
+	weights := []int{}
+	for _, server := range servers {
+		upstreams = append(upstreams, map[string]string{"dial": server.Address})
+		weights = append(weights, server.Weight)
+	}
+
+	handler := map[string]interface{}{
+		"handler":   "reverse_proxy",
+		"upstreams": upstreams,
+	}
+	if route.Weights != nil {
+		handler["load_balancing"] = map[string]interface{}{
+			"selection_policy": map[string]interface{}{
+				"policy":  "weighted_round_robin",
+				"weights": weights,
+			},
+		}
+	}
+
+	return handler
+}
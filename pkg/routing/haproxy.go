@@ -0,0 +1,108 @@
+package routing
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// HAProxyBackend renders the HTTP routes of the routing table as an HAProxy
+// configuration file, with one frontend and a backend for each route. TLS,
+// middlewares and load balancer settings are only supported by the Traefik
+// backend.
+type HAProxyBackend struct {
+	bind string
+}
+
+func NewHAProxyBackend() HAProxyBackend {
+	return HAProxyBackend{bind: viper.GetString("metis.routing.haproxy.bind")}
+}
+
+func (HAProxyBackend) Name() string {
+	return HAPROXY
+}
+
+func (HAProxyBackend) ContentType() string {
+	return "text/plain"
+}
+
+// unsafeName matches the characters HAProxy does not allow in names.
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9_.:-]`)
+
+func (b HAProxyBackend) Render(table Table) ([]byte, error) {
+	routes := table.HTTPRoutes()
+
+	var conf strings.Builder
+	conf.WriteString("# Generated by metis, do not edit\n\n")
+	// Without timeouts HAProxy warns on startup and keeps idle connections
+	// open forever
+	conf.WriteString("defaults\n")
+	conf.WriteString("    mode http\n")
+	conf.WriteString("    timeout connect 5s\n")
+	conf.WriteString("    timeout client 30s\n")
+	conf.WriteString("    timeout server 30s\n\n")
+	conf.WriteString("frontend metis\n")
+	fmt.Fprintf(&conf, "    bind %s\n", b.bind)
+	conf.WriteString("    mode http\n")
+	for _, route := range routes {
+		name := unsafeName.ReplaceAllString(route.Name, "_")
+		acls := []string{}
+		if hosts := route.Port.AllHosts(); len(hosts) > 0 {
+			fmt.Fprintf(&conf, "    acl %s_host req.hdr(host),field(1,:) -i %s\n", name, haproxyQuoteAll(hosts))
+			acls = append(acls, name+"_host")
+		}
+		if len(route.Port.PathPrefixes) > 0 {
+			fmt.Fprintf(&conf, "    acl %s_path path_beg %s\n", name, haproxyQuoteAll(route.Port.PathPrefixes))
+			acls = append(acls, name+"_path")
+		}
+		keys := []string{}
+		for key := range route.Port.Headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			fmt.Fprintf(&conf, "    acl %s_header%d req.hdr(%s) -m str %s\n", name, i, key, haproxyQuote(route.Port.Headers[key]))
+			acls = append(acls, fmt.Sprintf("%s_header%d", name, i))
+		}
+		if len(route.Port.Methods) > 0 {
+			fmt.Fprintf(&conf, "    acl %s_method method %s\n", name, strings.Join(route.Port.Methods, " "))
+			acls = append(acls, name+"_method")
+		}
+		fmt.Fprintf(&conf, "    use_backend %s if %s\n", name, strings.Join(acls, " "))
+	}
+
+	for _, route := range routes {
+		name := unsafeName.ReplaceAllString(route.Name, "_")
+		fmt.Fprintf(&conf, "\nbackend %s\n", name)
+		conf.WriteString("    mode http\n")
+		conf.WriteString("    balance roundrobin\n")
+		for i, server := range route.WeightedServers(256) {
+			fmt.Fprintf(&conf, "    server %s-%d %s weight %d\n", name, i, server.Address, server.Weight)
+		}
+	}
+
+	return []byte(conf.String()), nil
+}
+
+// haproxyQuote quotes a value so that HAProxy reads it as a single word,
+// using single quotes, in which nothing is expanded or escaped, and escaping
+// single quotes outside of them.
+func haproxyQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t'\"\\#$") {
+		return value
+	}
+
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func haproxyQuoteAll(values []string) string {
+	quoted := []string{}
+	for _, value := range values {
+		quoted = append(quoted, haproxyQuote(value))
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,49 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NginxBackend renders an nginx upstream for each HTTP route of the routing
+// table, named after the route, to be proxied to from server blocks managed
+// outside of metis.
+type NginxBackend struct{}
+
+func (NginxBackend) Name() string {
+	return NGINX
+}
+
+func (NginxBackend) ContentType() string {
+	return "text/plain"
+}
+
+func (NginxBackend) Render(table Table) ([]byte, error) {
+	var conf strings.Builder
+	conf.WriteString("# Generated by metis, do not edit\n")
+
+	for _, route := range table.Routes {
+		if !route.IsHTTP() {
+			continue
+		}
+
+		fmt.Fprintf(&conf, "\nupstream %s {\n", upstreamName(route.Name))
+		servers := route.WeightedServers(1000)
+		for _, server := range servers {
+			fmt.Fprintf(&conf, "    server %s weight=%d;\n", server.Address, server.Weight)
+		}
+		if len(servers) == 0 {
+			// nginx rejects upstreams without servers
+			conf.WriteString("    server 127.0.0.1:1 down;\n")
+		}
+		conf.WriteString("}\n")
+	}
+
+	return []byte(conf.String()), nil
+}
+
+// upstreamName returns the name of a route's upstream. The separator is
+// replaced, as proxy_pass would read it as the start of a port.
+func upstreamName(name string) string {
+	return strings.ReplaceAll(unsafeName.ReplaceAllString(name, "_"), Separator, "~")
+}
@@ -0,0 +1,152 @@
+package routing
+
+import (
+	"flag"
+	"io/ioutil"
+	"metis/pkg/project"
+	"metis/pkg/service"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var tables = map[string]Table{
+	"simple": {Routes: []Route{{
+		Name: "web",
+		Port: project.Port{
+			ContainerPort: 8080,
+			Protocol:      service.HTTP,
+			Host:          "web.example.com",
+		},
+		Servers: map[int][]string{1: {"10.0.0.1:32768", "10.0.0.2:32768"}},
+	}}},
+	// weighted sends all traffic to the stable revision of a blue/green
+	// deployment while the candidate comes up
+	"weighted": {Routes: []Route{{
+		Name: "web",
+		Port: project.Port{
+			ContainerPort: 8080,
+			Protocol:      service.HTTP,
+			Host:          "web.example.com",
+		},
+		Weights: map[int]int{1: 100},
+		Servers: map[int][]string{
+			1: {"10.0.0.1:32768", "10.0.0.2:32768"},
+			2: {"10.0.0.1:32769", "10.0.0.2:32769"},
+		},
+	}}},
+	"canary": {Routes: []Route{{
+		Name: "web",
+		Port: project.Port{
+			ContainerPort: 8080,
+			Protocol:      service.HTTP,
+			Host:          "web.example.com",
+			HTTPRoute: project.HTTPRoute{
+				PathPrefixes: []string{"/api"},
+				Headers:      map[string]string{"X-Tenant": "acme corp"},
+				Methods:      []string{"GET", "POST"},
+			},
+		},
+		Weights: map[int]int{1: 90, 2: 10},
+		Servers: map[int][]string{
+			1: {"10.0.0.1:32768", "10.0.0.2:32768"},
+			2: {"10.0.0.3:32768"},
+		},
+	}}},
+	"weighted-empty-servers": {Routes: []Route{{
+		Name: "web",
+		Port: project.Port{
+			ContainerPort: 8080,
+			Protocol:      service.HTTP,
+			Host:          "web.example.com",
+		},
+		Weights: map[int]int{1: 90, 2: 10},
+		Servers: map[int][]string{},
+	}}},
+	"empty-servers": {Routes: []Route{{
+		Name: "web",
+		Port: project.Port{
+			ContainerPort: 8080,
+			Protocol:      service.HTTP,
+			Host:          "web.example.com",
+		},
+		Servers: map[int][]string{},
+	}}},
+	"multi-port": {Routes: []Route{
+		{
+			Name: "app:http",
+			Port: project.Port{
+				Name:          "http",
+				ContainerPort: 8080,
+				Protocol:      service.HTTP,
+				Host:          "app.example.com",
+			},
+			Servers: map[int][]string{3: {"10.0.0.1:32770"}},
+		},
+		{
+			Name: "app:db",
+			Port: project.Port{
+				Name:          "db",
+				ContainerPort: 5432,
+				Protocol:      service.TCP,
+				SNI:           "db.example.com",
+				EntryPoints:   []string{"postgres"},
+			},
+			Servers: map[int][]string{3: {"10.0.0.1:32771"}},
+		},
+		{
+			Name: "app:dns",
+			Port: project.Port{
+				Name:          "dns",
+				ContainerPort: 53,
+				Protocol:      service.UDP,
+				EntryPoints:   []string{"dns"},
+			},
+			Servers: map[int][]string{3: {"10.0.0.1:32772"}},
+		},
+	}},
+}
+
+func TestRender(t *testing.T) {
+	backends := []RouterBackend{
+		TraefikBackend{},
+		CaddyBackend{listen: ":80"},
+		HAProxyBackend{bind: ":80"},
+		NginxBackend{},
+	}
+
+	for _, backend := range backends {
+		for name, table := range tables {
+			t.Run(backend.Name()+"/"+name, func(t *testing.T) {
+				got, err := backend.Render(table)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				golden := filepath.Join("testdata", backend.Name()+"-"+name+".golden")
+				if *update {
+					if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				want, err := ioutil.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != string(want) {
+					t.Errorf("rendered configuration does not match %s:\n%s", golden, got)
+				}
+
+				again, err := backend.Render(table)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(again) != string(got) {
+					t.Error("rendering is not deterministic")
+				}
+			})
+		}
+	}
+}
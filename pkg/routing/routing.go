@@ -0,0 +1,157 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"metis/pkg/project"
+	"metis/pkg/service"
+	"metis/pkg/traefik"
+	"sort"
+)
+
+const (
+	TRAEFIK = "traefik"
+	CADDY   = "caddy"
+	HAPROXY = "haproxy"
+	NGINX   = "nginx"
+)
+
+// Separator joins the parts of the names of routes and of the configuration
+// derived from them. Project and port names cannot contain it, so names
+// joined with it never collide.
+const Separator = ":"
+
+// RouterBackend renders the routing table into the configuration of a load
+// balancer. Rendering must be deterministic, so that unchanged routing
+// renders to the same configuration.
+type RouterBackend interface {
+	Name() string
+	ContentType() string
+	Render(table Table) ([]byte, error)
+}
+
+// Pusher is implemented by backends which are sent their configuration
+// whenever it changes.
+type Pusher interface {
+	Push(ctx context.Context, config []byte) error
+}
+
+// Table is the routing of every project, built from the controller's state.
+type Table struct {
+	Routes []Route
+}
+
+// Route is how traffic reaches a port of a project.
+type Route struct {
+	// Name is the name of the route, unique across projects
+	Name string
+	Port project.Port
+	// Weights holds the percentage of traffic each revision receives, or nil
+	// if traffic is not split by revision
+	Weights map[int]int
+	// Servers holds the node addresses of the project's ready services,
+	// keyed by revision
+	Servers map[int][]string
+}
+
+// New returns the backend with the given name.
+func New(name string) (RouterBackend, error) {
+	switch name {
+	case TRAEFIK:
+		return TraefikBackend{}, nil
+	case CADDY:
+		return NewCaddyBackend(), nil
+	case HAPROXY:
+		return NewHAProxyBackend(), nil
+	case NGINX:
+		return NginxBackend{}, nil
+	default:
+		return nil, fmt.Errorf("unknown router backend %q", name)
+	}
+}
+
+// IsHTTP reports whether the route is for an HTTP port.
+func (r Route) IsHTTP() bool {
+	return r.Port.Protocol == "" || r.Port.Protocol == service.HTTP
+}
+
+// HasMatchers reports whether the route matches any requests. Like Traefik,
+// HTTP routes without hosts or other matchers match none.
+func (r Route) HasMatchers() bool {
+	return len(r.Port.AllHosts()) > 0 || len(r.Port.PathPrefixes) > 0 ||
+		len(r.Port.Headers) > 0 || len(r.Port.Methods) > 0
+}
+
+// Priority returns the priority of an HTTP route, defaulting to the length of
+// its Traefik rule as Traefik does, so that every backend orders routes alike.
+func (r Route) Priority() int {
+	if r.Port.Priority > 0 {
+		return r.Port.Priority
+	}
+
+	return len(traefik.HTTPRule(r.Port.AllHosts(), r.Port.PathPrefixes, r.Port.Headers, r.Port.Methods))
+}
+
+// Revisions returns the revisions with servers, in order.
+func (r Route) Revisions() []int {
+	revisions := []int{}
+	for revision := range r.Servers {
+		revisions = append(revisions, revision)
+	}
+	sort.Ints(revisions)
+
+	return revisions
+}
+
+// WeightedServer is a server and its share of the route's traffic.
+type WeightedServer struct {
+	Address string
+	Weight  int
+}
+
+// WeightedServers returns the servers of the route for backends which weight
+// individual servers, scaling the weight of each revision's servers so that
+// together they receive the revision's share of traffic. Weights are at most
+// max, and servers of a revision with a zero weight are left out. Without
+// weights every server has a weight of 1.
+func (r Route) WeightedServers(max int) []WeightedServer {
+	servers := []WeightedServer{}
+	for _, revision := range r.Revisions() {
+		addresses := r.Servers[revision]
+		weight := 1
+		if r.Weights != nil {
+			if r.Weights[revision] == 0 {
+				continue
+			}
+			weight = r.Weights[revision] * max / (100 * len(addresses))
+			if weight < 1 {
+				weight = 1
+			}
+		}
+
+		for _, address := range addresses {
+			servers = append(servers, WeightedServer{Address: address, Weight: weight})
+		}
+	}
+
+	return servers
+}
+
+// HTTPRoutes returns the HTTP routes which match any requests, highest
+// priority first.
+func (t Table) HTTPRoutes() []Route {
+	routes := []Route{}
+	for _, route := range t.Routes {
+		if route.IsHTTP() && route.HasMatchers() {
+			routes = append(routes, route)
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Priority() != routes[j].Priority() {
+			return routes[i].Priority() > routes[j].Priority()
+		}
+		return routes[i].Name < routes[j].Name
+	})
+
+	return routes
+}
@@ -0,0 +1,251 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"metis/pkg/project"
+	"metis/pkg/service"
+	"metis/pkg/traefik"
+	"sort"
+)
+
+// TraefikBackend renders the routing table as Traefik dynamic configuration,
+// polled by Traefik's HTTP provider.
+type TraefikBackend struct{}
+
+func (TraefikBackend) Name() string {
+	return TRAEFIK
+}
+
+func (TraefikBackend) ContentType() string {
+	return "application/json"
+}
+
+func (b TraefikBackend) Render(table Table) ([]byte, error) {
+	return json.Marshal(b.Configuration(table).ToMap())
+}
+
+// Configuration builds the Traefik configuration of the routing table.
+func (TraefikBackend) Configuration(table Table) traefik.Configuration {
+	config := traefik.Configuration{
+		HTTP: traefik.HttpConfig{
+			Routers:     map[string]traefik.Router{},
+			Services:    map[string]traefik.Service{},
+			Middlewares: map[string]traefik.Middleware{},
+		},
+		TCP: traefik.TCPConfig{
+			Routers:  map[string]traefik.TCPRouter{},
+			Services: map[string]traefik.Service{},
+		},
+		UDP: traefik.UDPConfig{
+			Routers:  map[string]traefik.UDPRouter{},
+			Services: map[string]traefik.Service{},
+		},
+	}
+
+	for _, route := range table.Routes {
+		name, port := route.Name, route.Port
+
+		revisionServers := map[int][]traefik.Server{}
+		for revision, addresses := range route.Servers {
+			for _, address := range addresses {
+				server := traefik.Server{Address: address}
+				if route.IsHTTP() {
+					server = traefik.Server{URL: "http://" + address}
+				}
+				revisionServers[revision] = append(revisionServers[revision], server)
+			}
+		}
+
+		switch port.Protocol {
+		case service.TCP:
+			sni := port.SNI
+			if sni == "" {
+				sni = "*"
+			}
+			config.TCP.Routers[name+Separator+"router"] = traefik.TCPRouter{
+				Rule:           fmt.Sprintf("HostSNI(`%s`)", sni),
+				Service:        name,
+				EntryPoints:    port.EntryPoints,
+				TLSPassthrough: sni != "*",
+			}
+			addServices(config.TCP.Services, name, route.Weights, revisionServers, traefik.LoadBalancer{})
+		case service.UDP:
+			config.UDP.Routers[name+Separator+"router"] = traefik.UDPRouter{
+				Service:     name,
+				EntryPoints: port.EntryPoints,
+			}
+			addServices(config.UDP.Services, name, route.Weights, revisionServers, traefik.LoadBalancer{})
+		default:
+			config = addHTTPRouters(config, name, port)
+			addServices(config.HTTP.Services, name, route.Weights, revisionServers, loadBalancer(port))
+		}
+	}
+
+	return config
+}
+
+// loadBalancer returns the load balancer settings of an HTTP port, without
+// any servers.
+func loadBalancer(port project.Port) traefik.LoadBalancer {
+	balancer := traefik.LoadBalancer{}
+	settings := port.LoadBalancer
+	if settings == nil {
+		return balancer
+	}
+
+	if settings.HealthCheck != nil {
+		balancer.HealthCheck = &traefik.HealthCheck{Path: settings.HealthCheck.Path}
+		if settings.HealthCheck.IntervalSeconds > 0 {
+			balancer.HealthCheck.Interval = fmt.Sprintf("%ds", settings.HealthCheck.IntervalSeconds)
+		}
+		if settings.HealthCheck.TimeoutSeconds > 0 {
+			balancer.HealthCheck.Timeout = fmt.Sprintf("%ds", settings.HealthCheck.TimeoutSeconds)
+		}
+	}
+	if settings.Sticky != nil {
+		balancer.Sticky = &traefik.Sticky{
+			CookieName: settings.Sticky.CookieName,
+			Secure:     settings.Sticky.Secure,
+			HTTPOnly:   settings.Sticky.HTTPOnly,
+		}
+	}
+	balancer.PassHostHeader = settings.PassHostHeader
+
+	return balancer
+}
+
+// addServices adds the service routing to a port of a project. Without
+// weights the service balances over every server. Otherwise a load balancer is
+// added for each revision of the project, and a weighted service splitting the
+// traffic between them. Revisions without running services get no traffic.
+// Every load balancer takes its settings from balancer.
+func addServices(services map[string]traefik.Service, name string, weights map[int]int, revisionServers map[int][]traefik.Server, balancer traefik.LoadBalancer) {
+	revisions := []int{}
+	for revision := range revisionServers {
+		revisions = append(revisions, revision)
+	}
+	sort.Ints(revisions)
+
+	if weights == nil {
+		servers := []traefik.Server{}
+		for _, revision := range revisions {
+			servers = append(servers, revisionServers[revision]...)
+		}
+		balancer.Servers = servers
+		services[name] = traefik.Service{LoadBalancer: balancer}
+		return
+	}
+
+	// Sticky clients stay on the same revision as well as the same server
+	weighted := &traefik.Weighted{Sticky: balancer.Sticky}
+	for _, revision := range revisions {
+		balancer.Servers = revisionServers[revision]
+		services[fmt.Sprintf("%s%srev%d", name, Separator, revision)] = traefik.Service{LoadBalancer: balancer}
+		if weights[revision] > 0 {
+			weighted.Services = append(weighted.Services, traefik.WeightedService{
+				Name:   fmt.Sprintf("%s%srev%d", name, Separator, revision),
+				Weight: weights[revision],
+			})
+		}
+	}
+
+	if len(weighted.Services) == 0 {
+		// Traefik rejects services that are neither weighted nor balanced
+		balancer.Servers = []traefik.Server{}
+		services[name] = traefik.Service{LoadBalancer: balancer}
+		return
+	}
+	services[name] = traefik.Service{Weighted: weighted}
+}
+
+// addHTTPRouters adds the routers of an HTTP port, along with its middlewares
+// and certificates. Ports served over HTTPS get a second router redirecting
+// plain HTTP requests if the port's TLS has redirect entrypoints.
+func addHTTPRouters(config traefik.Configuration, name string, port project.Port) traefik.Configuration {
+	middlewares := []string{}
+	for i, middleware := range port.Middlewares {
+		middlewareName := fmt.Sprintf("%s%smiddleware-%d", name, Separator, i)
+		config.HTTP.Middlewares[middlewareName] = traefikMiddleware(middleware)
+		middlewares = append(middlewares, middlewareName)
+	}
+
+	router := traefik.Router{
+		Rule:        traefik.HTTPRule(port.AllHosts(), port.PathPrefixes, port.Headers, port.Methods),
+		Service:     name,
+		EntryPoints: port.EntryPoints,
+		Priority:    port.Priority,
+		Middlewares: middlewares,
+	}
+
+	tls := port.TLS
+	if tls == nil {
+		config.HTTP.Routers[name+Separator+"router"] = router
+		return config
+	}
+
+	if len(tls.RedirectFrom) > 0 {
+		config.HTTP.Middlewares[name+Separator+"redirect-https"] = traefik.Middleware{
+			RedirectScheme: &traefik.RedirectScheme{Scheme: "https", Permanent: true},
+		}
+		config.HTTP.Routers[name+Separator+"redirect-router"] = traefik.Router{
+			Rule:        router.Rule,
+			Service:     name,
+			EntryPoints: tls.RedirectFrom,
+			Priority:    port.Priority,
+			Middlewares: []string{name + Separator + "redirect-https"},
+		}
+	}
+
+	if tls.CertFile != "" {
+		config.TLS.Certificates = append(config.TLS.Certificates, traefik.Certificate{
+			CertFile: tls.CertFile,
+			KeyFile:  tls.KeyFile,
+		})
+	}
+
+	router.EntryPoints = tls.EntryPoints
+	router.TLS = &traefik.RouterTLS{
+		CertResolver: tls.CertResolver,
+		Options:      tls.Options,
+	}
+	config.HTTP.Routers[name+Separator+"router"] = router
+
+	return config
+}
+
+func traefikMiddleware(middleware project.Middleware) traefik.Middleware {
+	switch {
+	case middleware.RedirectScheme != nil:
+		scheme := middleware.RedirectScheme.Scheme
+		if scheme == "" {
+			scheme = "https"
+		}
+		return traefik.Middleware{RedirectScheme: &traefik.RedirectScheme{
+			Scheme:    scheme,
+			Permanent: middleware.RedirectScheme.Permanent,
+		}}
+	case middleware.BasicAuth != nil:
+		return traefik.Middleware{BasicAuth: &traefik.BasicAuth{
+			Users: middleware.BasicAuth.Users,
+			Realm: middleware.BasicAuth.Realm,
+		}}
+	case middleware.RateLimit != nil:
+		period := middleware.RateLimit.PeriodSeconds
+		if period == 0 {
+			period = 1
+		}
+		return traefik.Middleware{RateLimit: &traefik.RateLimit{
+			Average: middleware.RateLimit.Average,
+			Burst:   middleware.RateLimit.Burst,
+			Period:  fmt.Sprintf("%ds", period),
+		}}
+	case middleware.Headers != nil:
+		return traefik.Middleware{Headers: &traefik.Headers{
+			CustomRequestHeaders:  middleware.Headers.Request,
+			CustomResponseHeaders: middleware.Headers.Response,
+		}}
+	}
+
+	return traefik.Middleware{Compress: middleware.Compress}
+}
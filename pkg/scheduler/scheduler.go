@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"fmt"
+	"metis/pkg/node"
+	"metis/pkg/project"
+	"metis/pkg/service"
+	"metis/pkg/state"
+	"metis/pkg/status"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	SPREAD      = "spread"
+	BINPACK     = "binpack"
+	LEASTLOADED = "leastloaded"
+)
+
+// Scheduler picks the node a new service of a project is placed on.
+type Scheduler interface {
+	Schedule(proj project.Project, cluster Cluster) (node.Node, error)
+}
+
+// Cluster is the view of the cluster a scheduler decides on.
+type Cluster struct {
+	Nodes    []node.Node
+	Services []state.ServiceState
+}
+
+// UnschedulableError is returned when no node can run a project's service.
+type UnschedulableError struct {
+	Reason string
+}
+
+func (e UnschedulableError) Error() string {
+	return "unschedulable: " + e.Reason
+}
+
+// New returns the scheduler for a strategy. Spread schedulers spread services
+// across the values of spreadLabel as well as across nodes.
+func New(strategy string, spreadLabel string) (Scheduler, error) {
+	switch strategy {
+	case SPREAD:
+		return Spread{Label: spreadLabel}, nil
+	case BINPACK:
+		return BinPack{}, nil
+	case LEASTLOADED:
+		return LeastLoaded{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown scheduling strategy %q", strategy)
+}
+
+// ForProject returns the scheduler configured for the project, falling back
+// to the global metis.scheduler settings.
+func ForProject(proj project.Project) (Scheduler, error) {
+	strategy := proj.Configuration.Placement.Strategy
+	if strategy == "" {
+		strategy = viper.GetString("metis.scheduler.strategy")
+	}
+	spreadLabel := proj.Configuration.Placement.SpreadLabel
+	if spreadLabel == "" {
+		spreadLabel = viper.GetString("metis.scheduler.spread_label")
+	}
+
+	return New(strategy, spreadLabel)
+}
+
+// candidates returns the healthy, non-draining nodes that satisfy the
+// placement constraints of the project with the highest preference score,
+// sorted by ID.
+func candidates(proj project.Project, cluster Cluster) ([]node.Node, error) {
+	nodes := []node.Node{}
+	for _, nd := range cluster.Nodes {
+		if nd.Healthy && !nd.Draining {
+			nodes = append(nodes, nd)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, UnschedulableError{Reason: "no healthy nodes"}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	placement := proj.Configuration.Placement
+	placement.Required = proj.Configuration.RequiredLabels()
+	requests := proj.Configuration.Resources.Requests
+	used := usedByNode(cluster.Services)
+	matched := false
+	best := []node.Node{}
+	bestScore := 0
+	for _, nd := range nodes {
+		if !satisfies(nd, placement) {
+			continue
+		}
+		matched = true
+		if !used[nd.ID].Add(requests).Fits(nd.Capacity) {
+			continue
+		}
+
+		score := preferenceScore(nd, placement)
+		if len(best) == 0 || score > bestScore {
+			best = []node.Node{nd}
+			bestScore = score
+		} else if score == bestScore {
+			best = append(best, nd)
+		}
+	}
+
+	if !matched {
+		return nil, UnschedulableError{Reason: fmt.Sprintf(
+			"no healthy node matches placement (required: [%s], excluded: [%s])",
+			strings.Join(placement.Required, ", "),
+			strings.Join(placement.Excluded, ", "),
+		)}
+	}
+	if len(best) == 0 {
+		return nil, UnschedulableError{Reason: fmt.Sprintf(
+			"no matching node has free capacity for %g CPU and %dMB memory",
+			requests.CPU, requests.Memory,
+		)}
+	}
+
+	return best, nil
+}
+
+// satisfies reports whether the node has every required label and none of
+// the excluded labels.
+func satisfies(nd node.Node, placement project.Placement) bool {
+	for _, label := range placement.Required {
+		if !nd.HasLabel(label) {
+			return false
+		}
+	}
+	for _, label := range nd.Labels {
+		if placement.Excludes(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func preferenceScore(nd node.Node, placement project.Placement) int {
+	score := 0
+	for _, preferred := range placement.Preferred {
+		if nd.HasLabel(preferred.Label) {
+			score += preferred.Weight
+		}
+	}
+
+	return score
+}
+
+// countByNode counts the services holding resources on each node,
+// optionally only those of a single project.
+func countByNode(services []state.ServiceState, projectName string) map[string]int {
+	counts := map[string]int{}
+	for _, srv := range services {
+		if !holdsResources(srv) || (projectName != "" && srv.Service.SrvName != projectName) {
+			continue
+		}
+		counts[srv.Node]++
+	}
+
+	return counts
+}
+
+// usedByNode sums the resources requested by the services on each node.
+func usedByNode(services []state.ServiceState) map[string]service.Resources {
+	used := map[string]service.Resources{}
+	for _, srv := range services {
+		if !holdsResources(srv) {
+			continue
+		}
+		used[srv.Node] = used[srv.Node].Add(srv.Service.Resources.Requests)
+	}
+
+	return used
+}
+
+// holdsResources reports whether a service is running or starting. Services
+// that were stopped, lost or ended, and those that exited or are unhealthy
+// and about to be removed, no longer hold resources.
+func holdsResources(srv state.ServiceState) bool {
+	return srv.Status == status.RUNNING || srv.Status == status.CREATED
+}
+
+// utilisation returns the fraction of the node's capacity used, taking the
+// most used of CPU and memory. Nodes with unknown capacity are unused.
+func utilisation(nd node.Node, used service.Resources) float64 {
+	fraction := 0.0
+	if nd.Capacity.CPU > 0 && used.CPU/nd.Capacity.CPU > fraction {
+		fraction = used.CPU / nd.Capacity.CPU
+	}
+	if nd.Capacity.Memory > 0 && float64(used.Memory)/float64(nd.Capacity.Memory) > fraction {
+		fraction = float64(used.Memory) / float64(nd.Capacity.Memory)
+	}
+
+	return fraction
+}
@@ -0,0 +1,299 @@
+package scheduler
+
+import (
+	"errors"
+	"metis/pkg/node"
+	"metis/pkg/project"
+	"metis/pkg/service"
+	"metis/pkg/state"
+	"metis/pkg/status"
+	"testing"
+)
+
+func healthyNode(id string, labels ...string) node.Node {
+	return node.Node{ID: id, Healthy: true, Labels: labels}
+}
+
+func running(projectName string, nodeID string) state.ServiceState {
+	return state.ServiceState{
+		Status:  status.RUNNING,
+		Service: service.DockerService{SrvName: projectName},
+		Node:    nodeID,
+	}
+}
+
+func requesting(srv state.ServiceState, cpu float64, memory int64) state.ServiceState {
+	srv.Service.Resources.Requests = service.Resources{CPU: cpu, Memory: memory}
+	return srv
+}
+
+func nodeIDs(nodes []node.Node) []string {
+	ids := []string{}
+	for _, nd := range nodes {
+		ids = append(ids, nd.ID)
+	}
+
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestCandidates(t *testing.T) {
+	draining := healthyNode("node-d")
+	draining.Draining = true
+	full := healthyNode("node-f")
+	full.Capacity = service.Resources{CPU: 1, Memory: 512}
+
+	tests := []struct {
+		name          string
+		configuration project.ProjectConfiguration
+		cluster       Cluster
+		want          []string
+		unschedulable bool
+	}{
+		{
+			name:    "healthy nodes sorted by ID",
+			cluster: Cluster{Nodes: []node.Node{healthyNode("node-b"), healthyNode("node-a"), {ID: "node-c"}}},
+			want:    []string{"node-a", "node-b"},
+		},
+		{
+			name:          "no healthy nodes",
+			cluster:       Cluster{Nodes: []node.Node{{ID: "node-a"}, draining}},
+			unschedulable: true,
+		},
+		{
+			name: "required and excluded labels",
+			configuration: project.ProjectConfiguration{Placement: project.Placement{
+				Required: []string{"ssd"},
+				Excluded: []string{"zone=b"},
+			}},
+			cluster: Cluster{Nodes: []node.Node{
+				healthyNode("node-a", "ssd", "zone=a"),
+				healthyNode("node-b", "ssd", "zone=b"),
+				healthyNode("node-c", "zone=a"),
+			}},
+			want: []string{"node-a"},
+		},
+		{
+			name: "mount labels are required",
+			configuration: project.ProjectConfiguration{Mounts: []service.Mount{
+				{Type: service.VOLUME, Source: "data", Target: "/data", Label: "storage"},
+			}},
+			cluster: Cluster{Nodes: []node.Node{healthyNode("node-a"), healthyNode("node-b", "storage")}},
+			want:    []string{"node-b"},
+		},
+		{
+			name: "no node matches placement",
+			configuration: project.ProjectConfiguration{Placement: project.Placement{
+				Required: []string{"gpu"},
+			}},
+			cluster:       Cluster{Nodes: []node.Node{healthyNode("node-a")}},
+			unschedulable: true,
+		},
+		{
+			name: "highest preference score wins",
+			configuration: project.ProjectConfiguration{Placement: project.Placement{
+				Preferred: []project.PreferredLabel{{Label: "ssd", Weight: 2}, {Label: "zone=a", Weight: 1}},
+			}},
+			cluster: Cluster{Nodes: []node.Node{
+				healthyNode("node-a", "zone=a"),
+				healthyNode("node-b", "ssd"),
+				healthyNode("node-c", "ssd"),
+			}},
+			want: []string{"node-b", "node-c"},
+		},
+		{
+			name: "nodes without free capacity are skipped",
+			configuration: project.ProjectConfiguration{Resources: service.ResourceRequirements{
+				Requests: service.Resources{CPU: 0.5, Memory: 256},
+			}},
+			cluster: Cluster{
+				Nodes:    []node.Node{full, healthyNode("node-g")},
+				Services: []state.ServiceState{requesting(running("other", "node-f"), 0.75, 128)},
+			},
+			want: []string{"node-g"},
+		},
+		{
+			name: "ended services free their capacity",
+			configuration: project.ProjectConfiguration{Resources: service.ResourceRequirements{
+				Requests: service.Resources{CPU: 0.5},
+			}},
+			cluster: Cluster{
+				Nodes: []node.Node{full},
+				Services: []state.ServiceState{
+					requesting(state.ServiceState{Status: status.COMPLETED, Node: "node-f"}, 1, 0),
+					requesting(state.ServiceState{Status: status.UNHEALTHY, Node: "node-f"}, 1, 0),
+				},
+			},
+			want: []string{"node-f"},
+		},
+		{
+			name: "no matching node has free capacity",
+			configuration: project.ProjectConfiguration{Resources: service.ResourceRequirements{
+				Requests: service.Resources{CPU: 2},
+			}},
+			cluster:       Cluster{Nodes: []node.Node{full}},
+			unschedulable: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proj := project.Project{Name: "web", Configuration: test.configuration}
+			nodes, err := candidates(proj, test.cluster)
+			if test.unschedulable {
+				if !errors.As(err, &UnschedulableError{}) {
+					t.Fatalf("expected an unschedulable error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := nodeIDs(nodes); !equalIDs(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	capacity := func(nd node.Node) node.Node {
+		nd.Capacity = service.Resources{CPU: 4, Memory: 4096}
+		return nd
+	}
+
+	tests := []struct {
+		name      string
+		scheduler Scheduler
+		nodes     []node.Node
+		services  []state.ServiceState
+		want      string
+	}{
+		{
+			name:      "spread picks the node with fewest of the project",
+			scheduler: Spread{},
+			nodes:     []node.Node{healthyNode("node-a"), healthyNode("node-b")},
+			services: []state.ServiceState{
+				running("web", "node-a"),
+				running("other", "node-b"),
+				running("other", "node-b"),
+			},
+			want: "node-b",
+		},
+		{
+			name:      "spread breaks ties by total load",
+			scheduler: Spread{},
+			nodes:     []node.Node{healthyNode("node-a"), healthyNode("node-b")},
+			services:  []state.ServiceState{running("other", "node-a")},
+			want:      "node-b",
+		},
+		{
+			name:      "spread across label values first",
+			scheduler: Spread{Label: "zone"},
+			nodes: []node.Node{
+				healthyNode("node-a", "zone=a"),
+				healthyNode("node-b", "zone=a"),
+				healthyNode("node-c", "zone=b"),
+			},
+			services: []state.ServiceState{running("web", "node-a"), running("other", "node-c")},
+			want:     "node-c",
+		},
+		{
+			name:      "binpack picks the most utilised node",
+			scheduler: BinPack{},
+			nodes:     []node.Node{capacity(healthyNode("node-a")), capacity(healthyNode("node-b"))},
+			services:  []state.ServiceState{requesting(running("other", "node-b"), 2, 1024)},
+			want:      "node-b",
+		},
+		{
+			name:      "binpack packs by count without capacity",
+			scheduler: BinPack{},
+			nodes:     []node.Node{healthyNode("node-a"), healthyNode("node-b")},
+			services:  []state.ServiceState{running("other", "node-b")},
+			want:      "node-b",
+		},
+		{
+			name:      "least loaded picks the node running fewest services",
+			scheduler: LeastLoaded{},
+			nodes:     []node.Node{healthyNode("node-a"), healthyNode("node-b")},
+			services:  []state.ServiceState{running("web", "node-a"), running("other", "node-a")},
+			want:      "node-b",
+		},
+		{
+			name:      "services that are not running are not load",
+			scheduler: LeastLoaded{},
+			nodes:     []node.Node{healthyNode("node-a"), healthyNode("node-b")},
+			services: []state.ServiceState{
+				{Status: status.STOPPED, Node: "node-a"},
+				{Status: status.LOST, Node: "node-a"},
+				{Status: status.COMPLETED, Node: "node-a"},
+				{Status: status.FAILED, Node: "node-a"},
+				{Status: status.EXITED, Node: "node-a"},
+				{Status: status.UNHEALTHY, Node: "node-a"},
+				running("other", "node-b"),
+			},
+			want: "node-a",
+		},
+		{
+			name:      "spread ignores ended services of the project",
+			scheduler: Spread{},
+			nodes:     []node.Node{healthyNode("node-a"), healthyNode("node-b")},
+			services: []state.ServiceState{
+				{Status: status.FAILED, Service: service.DockerService{SrvName: "web"}, Node: "node-a"},
+				{Status: status.FAILED, Service: service.DockerService{SrvName: "web"}, Node: "node-a"},
+				running("web", "node-b"),
+			},
+			want: "node-a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proj := project.Project{Name: "web"}
+			nd, err := test.scheduler.Schedule(proj, Cluster{Nodes: test.nodes, Services: test.services})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if nd.ID != test.want {
+				t.Errorf("got %s, want %s", nd.ID, test.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     Scheduler
+	}{
+		{strategy: SPREAD, want: Spread{Label: "zone"}},
+		{strategy: BINPACK, want: BinPack{}},
+		{strategy: LEASTLOADED, want: LeastLoaded{}},
+	}
+
+	for _, test := range tests {
+		sched, err := New(test.strategy, "zone")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.strategy, err)
+		}
+		if sched != test.want {
+			t.Errorf("%s: got %#v, want %#v", test.strategy, sched, test.want)
+		}
+	}
+
+	if _, err := New("random", ""); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"metis/pkg/node"
+	"metis/pkg/project"
+)
+
+// Spread places services of a project on the nodes running the fewest of
+// them, so that no node runs two instances while another runs none. If Label
+// is set, services are first spread across the values of that label, such as
+// zone for nodes labelled zone=a and zone=b.
+type Spread struct {
+	Label string
+}
+
+func (s Spread) Schedule(proj project.Project, cluster Cluster) (node.Node, error) {
+	nodes, err := candidates(proj, cluster)
+	if err != nil {
+		return node.Node{}, err
+	}
+
+	perNode := countByNode(cluster.Services, proj.Name)
+	perGroup := map[string]int{}
+	if s.Label != "" {
+		for _, nd := range cluster.Nodes {
+			perGroup[nd.LabelValue(s.Label)] += perNode[nd.ID]
+		}
+	}
+	load := countByNode(cluster.Services, "")
+
+	best := nodes[0]
+	for _, nd := range nodes[1:] {
+		if less(
+			[]int{perGroup[nd.LabelValue(s.Label)], perNode[nd.ID], load[nd.ID]},
+			[]int{perGroup[best.LabelValue(s.Label)], perNode[best.ID], load[best.ID]},
+		) {
+			best = nd
+		}
+	}
+
+	return best, nil
+}
+
+// BinPack places services on the node with the most of its capacity in use
+// that can still run them, keeping other nodes free. Nodes without a known
+// capacity are packed by number of services.
+type BinPack struct{}
+
+func (BinPack) Schedule(proj project.Project, cluster Cluster) (node.Node, error) {
+	nodes, err := candidates(proj, cluster)
+	if err != nil {
+		return node.Node{}, err
+	}
+
+	used := usedByNode(cluster.Services)
+	load := countByNode(cluster.Services, "")
+	best := nodes[0]
+	for _, nd := range nodes[1:] {
+		ndUtil, bestUtil := utilisation(nd, used[nd.ID]), utilisation(best, used[best.ID])
+		if ndUtil > bestUtil || (ndUtil == bestUtil && load[nd.ID] > load[best.ID]) {
+			best = nd
+		}
+	}
+
+	return best, nil
+}
+
+// LeastLoaded places services on the node running the fewest services.
+type LeastLoaded struct{}
+
+func (LeastLoaded) Schedule(proj project.Project, cluster Cluster) (node.Node, error) {
+	nodes, err := candidates(proj, cluster)
+	if err != nil {
+		return node.Node{}, err
+	}
+
+	load := countByNode(cluster.Services, "")
+	best := nodes[0]
+	for _, nd := range nodes[1:] {
+		if load[nd.ID] < load[best.ID] {
+			best = nd
+		}
+	}
+
+	return best, nil
+}
+
+// less compares two keys lexicographically.
+func less(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,212 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+	ErrSecretExists   = errors.New("secret already exists")
+)
+
+// Store keeps secrets encrypted at rest in a file, using AES-GCM with a key
+// derived from a passphrase.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	aead    cipher.AEAD
+	secrets map[string]entry
+}
+
+type entry struct {
+	Version    int       `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// Info describes a secret without its value.
+type Info struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewStore opens the store at path, loading any secrets already in it.
+func NewStore(path string, passphrase string) (*Store, error) {
+	if passphrase == "" {
+		return nil, errors.New("secrets key is not set")
+	}
+
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: path, aead: aead, secrets: make(map[string]entry)}
+
+	byts, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(byts, &s.secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the key can decrypt the existing secrets
+	for name := range s.secrets {
+		if _, _, err := s.get(name); err != nil {
+			return nil, errors.New("could not decrypt secrets, is the secrets key correct?")
+		}
+	}
+
+	return s, nil
+}
+
+// List returns every secret, without values, sorted by name.
+func (s *Store) List() []Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := []Info{}
+	for name, e := range s.secrets {
+		infos = append(infos, Info{Name: name, Version: e.Version, UpdatedAt: e.UpdatedAt})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
+
+// Get returns the value and version of a secret.
+func (s *Store) Get(name string) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.get(name)
+}
+
+func (s *Store) get(name string) (string, int, error) {
+	e, ok := s.secrets[name]
+	if !ok {
+		return "", 0, ErrSecretNotFound
+	}
+
+	plaintext, err := s.aead.Open(nil, e.Nonce, e.Ciphertext, []byte(name))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return string(plaintext), e.Version, nil
+}
+
+// Create adds a new secret.
+func (s *Store) Create(name string, value string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.secrets[name]; ok {
+		return Info{}, ErrSecretExists
+	}
+
+	return s.set(name, value, 1)
+}
+
+// Rotate replaces the value of an existing secret with a new version.
+func (s *Store) Rotate(name string, value string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.secrets[name]
+	if !ok {
+		return Info{}, ErrSecretNotFound
+	}
+
+	return s.set(name, value, e.Version+1)
+}
+
+// Delete removes a secret.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.secrets[name]
+	if !ok {
+		return ErrSecretNotFound
+	}
+
+	delete(s.secrets, name)
+	err := s.write()
+	if err != nil {
+		s.secrets[name] = e
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) set(name string, value string, version int) (Info, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return Info{}, err
+	}
+
+	previous, existed := s.secrets[name]
+	e := entry{
+		Version:    version,
+		UpdatedAt:  time.Now(),
+		Nonce:      nonce,
+		Ciphertext: s.aead.Seal(nil, nonce, []byte(value), []byte(name)),
+	}
+	s.secrets[name] = e
+
+	err = s.write()
+	if err != nil {
+		if existed {
+			s.secrets[name] = previous
+		} else {
+			delete(s.secrets, name)
+		}
+		return Info{}, err
+	}
+
+	return Info{Name: name, Version: e.Version, UpdatedAt: e.UpdatedAt}, nil
+}
+
+func (s *Store) write() error {
+	err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	marsh, err := json.Marshal(s.secrets)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.path, marsh, 0600)
+}
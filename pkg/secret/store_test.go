@@ -0,0 +1,113 @@
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempStorePath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "metis-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "secrets.json")
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := tempStorePath(t)
+	store, err := NewStore(path, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "db-password", value: "hunter2"},
+		{name: "empty", value: ""},
+		{name: "multiline", value: "-----BEGIN KEY-----\nabc\n-----END KEY-----\n"},
+	}
+
+	for _, test := range tests {
+		info, err := store.Create(test.name, test.value)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if info.Version != 1 {
+			t.Errorf("%s: got version %d, want 1", test.name, info.Version)
+		}
+	}
+
+	// Values are not stored in plaintext
+	byts, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(byts, []byte("hunter2")) {
+		t.Error("secret value written in plaintext")
+	}
+
+	reopened, err := NewStore(path, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		value, version, err := reopened.Get(test.name)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if value != test.value || version != 1 {
+			t.Errorf("%s: got %q at version %d, want %q at version 1", test.name, value, version, test.value)
+		}
+	}
+
+	if _, err := NewStore(path, "wrong"); err == nil {
+		t.Error("expected an error opening the store with the wrong key")
+	}
+}
+
+func TestStoreLifecycle(t *testing.T) {
+	store, err := NewStore(tempStorePath(t), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Create("token", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("token", "b"); !errors.Is(err, ErrSecretExists) {
+		t.Errorf("got %v creating a duplicate, want ErrSecretExists", err)
+	}
+
+	info, err := store.Rotate("token", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 2 {
+		t.Errorf("got version %d after rotating, want 2", info.Version)
+	}
+	if value, _, _ := store.Get("token"); value != "b" {
+		t.Errorf("got %q after rotating, want b", value)
+	}
+	if _, err := store.Rotate("missing", "a"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("got %v rotating a missing secret, want ErrSecretNotFound", err)
+	}
+
+	if list := store.List(); len(list) != 1 || list[0].Name != "token" {
+		t.Errorf("got %v listed, want token", list)
+	}
+
+	if err := store.Delete("token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Get("token"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("got %v after deleting, want ErrSecretNotFound", err)
+	}
+}
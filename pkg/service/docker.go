@@ -1,14 +1,54 @@
 package service
 
-import "metis/pkg/status"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"metis/pkg/status"
+)
 
 type DockerService struct {
 	SrvName       string               `json:"name"`
 	DockerImage   string               `json:"docker_image"`
 	DesiredStatus status.ServiceStatus `json:"desired_status"`
 	ContainerPort int                  `json:"container_port"`
+	Ports         []Port               `json:"ports"`
+	Resources     ResourceRequirements `json:"resources"`
+	Revision      int                  `json:"revision"`
+
+	Env        map[string]string `json:"env"`
+	Entrypoint []string          `json:"entrypoint"`
+	Command    []string          `json:"command"`
+	Args       []string          `json:"args"`
+	WorkingDir string            `json:"working_dir"`
+	User       string            `json:"user"`
+
+	Mounts  []Mount     `json:"mounts"`
+	Secrets []SecretRef `json:"secrets"`
+	// SecretValues holds the values of the secrets, keyed by name. They are
+	// only sent to the agent alongside the service and never serialised with
+	// it.
+	SecretValues map[string]string `json:"-"`
+	// Controller is the ID of the controller creating the service, which
+	// the agent labels its container with.
+	Controller string `json:"-"`
+
+	LivenessProbe  *Probe `json:"liveness_probe"`
+	ReadinessProbe *Probe `json:"readiness_probe"`
 }
 
 func (s DockerService) Name() string {
 	return s.SrvName
 }
+
+// Spec returns a hash of the configuration of the service, leaving out its
+// revision, so that containers can be matched with the configuration they
+// were created with even once revisions have been renumbered.
+func (s DockerService) Spec() string {
+	s.Revision = 0
+	// Marshalling cannot fail, as the service holds no channels or funcs
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,26 @@
+package service
+
+import "testing"
+
+func TestDockerServiceSpec(t *testing.T) {
+	srv := DockerService{SrvName: "web", DockerImage: "web:1", Revision: 1, Env: map[string]string{"A": "1", "B": "2"}}
+
+	tests := []struct {
+		name string
+		srv  DockerService
+		same bool
+	}{
+		{name: "other revision", srv: DockerService{SrvName: "web", DockerImage: "web:1", Revision: 4, Env: map[string]string{"B": "2", "A": "1"}}, same: true},
+		{name: "other controller", srv: DockerService{SrvName: "web", DockerImage: "web:1", Revision: 1, Env: map[string]string{"A": "1", "B": "2"}, Controller: "other"}, same: true},
+		{name: "other image", srv: DockerService{SrvName: "web", DockerImage: "web:2", Revision: 1, Env: map[string]string{"A": "1", "B": "2"}}},
+		{name: "other env", srv: DockerService{SrvName: "web", DockerImage: "web:1", Revision: 1, Env: map[string]string{"A": "1"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := test.srv.Spec() == srv.Spec(); same != test.same {
+				t.Errorf("got same spec %t, want %t", same, test.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	VOLUME = "volume"
+	BIND   = "bind"
+	TMPFS  = "tmpfs"
+)
+
+// Mount attaches a named volume, a host path or a tmpfs to a service.
+type Mount struct {
+	// Type is one of volume, bind or tmpfs
+	Type string `json:"type"`
+	// Source is the name of the volume or the absolute host path of a bind
+	// mount, and is unused for tmpfs mounts
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+	// SizeMB limits the size of a tmpfs mount, unlimited if zero
+	SizeMB int64 `json:"size_mb"`
+	// Label restricts services to nodes with the label, for volumes and host
+	// paths that only exist on some nodes
+	Label string `json:"label"`
+}
+
+func (m Mount) Validate() error {
+	if !path.IsAbs(m.Target) {
+		return errors.New("mount target must be an absolute path")
+	}
+	if m.SizeMB < 0 {
+		return errors.New("size_mb cannot be negative")
+	}
+	if m.SizeMB > 0 && m.Type != TMPFS {
+		return errors.New("size_mb is only supported by tmpfs mounts")
+	}
+
+	switch m.Type {
+	case VOLUME:
+		if m.Source == "" || strings.Contains(m.Source, "/") {
+			return errors.New("volume mounts need a volume name as their source")
+		}
+	case BIND:
+		if !path.IsAbs(m.Source) {
+			return errors.New("bind mounts need an absolute host path as their source")
+		}
+		if m.Label == "" {
+			// The controller cannot see which host paths each agent allows
+			return errors.New("bind mounts need a label for the nodes that allow their host path")
+		}
+	case TMPFS:
+		if m.Source != "" {
+			return errors.New("tmpfs mounts do not take a source")
+		}
+	default:
+		return fmt.Errorf("unknown mount type %q", m.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import "testing"
+
+func TestMountValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		mount Mount
+		valid bool
+	}{
+		{name: "volume", mount: Mount{Type: VOLUME, Source: "data", Target: "/data"}, valid: true},
+		{name: "volume with a path", mount: Mount{Type: VOLUME, Source: "/data", Target: "/data"}},
+		{name: "relative target", mount: Mount{Type: VOLUME, Source: "data", Target: "data"}},
+		{
+			name:  "bind with a label",
+			mount: Mount{Type: BIND, Source: "/srv/data", Target: "/data", Label: "data"},
+			valid: true,
+		},
+		{name: "bind without a label", mount: Mount{Type: BIND, Source: "/srv/data", Target: "/data"}},
+		{name: "bind with a relative source", mount: Mount{Type: BIND, Source: "data", Target: "/data", Label: "data"}},
+		{name: "tmpfs", mount: Mount{Type: TMPFS, Target: "/tmp", SizeMB: 64}, valid: true},
+		{name: "tmpfs with a source", mount: Mount{Type: TMPFS, Source: "tmp", Target: "/tmp"}},
+		{name: "size on a volume", mount: Mount{Type: VOLUME, Source: "data", Target: "/data", SizeMB: 64}},
+		{name: "unknown type", mount: Mount{Type: "nfs", Source: "data", Target: "/data"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.mount.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package service
+
+const (
+	HTTP = "http"
+	TCP  = "tcp"
+	UDP  = "udp"
+)
+
+// Port is a container port of a service, published on a port of the node.
+type Port struct {
+	Name          string `json:"name"`
+	ContainerPort int    `json:"container_port"`
+	// Protocol is the transport of the port, tcp or udp
+	Protocol string `json:"protocol"`
+}
+
+// PublishedPorts returns the ports of the service, or its container port if
+// it does not list any.
+func (s DockerService) PublishedPorts() []Port {
+	if len(s.Ports) > 0 {
+		return s.Ports
+	}
+
+	return []Port{{ContainerPort: s.ContainerPort, Protocol: TCP}}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"time"
+)
+
+// Probe checks the health of a running service with exactly one of an HTTP
+// request, a TCP connection or a command run in the container. Zero values
+// take the defaults below.
+type Probe struct {
+	HTTP *HTTPProbe `json:"http"`
+	TCP  *TCPProbe  `json:"tcp"`
+	Exec *ExecProbe `json:"exec"`
+
+	InitialDelaySeconds int `json:"initial_delay_seconds"`
+	// IntervalSeconds defaults to 10
+	IntervalSeconds int `json:"interval_seconds"`
+	// TimeoutSeconds defaults to 1
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// SuccessThreshold is how many checks in a row must pass for a failing
+	// probe to pass, defaulting to 1
+	SuccessThreshold int `json:"success_threshold"`
+	// FailureThreshold is how many checks in a row must fail for a passing
+	// probe to fail, defaulting to 3
+	FailureThreshold int `json:"failure_threshold"`
+}
+
+type HTTPProbe struct {
+	Path string `json:"path"`
+	// Port defaults to the container port of the service
+	Port int `json:"port"`
+	// ExpectedStatus defaults to any 2xx or 3xx status
+	ExpectedStatus int `json:"expected_status"`
+}
+
+type TCPProbe struct {
+	// Port defaults to the container port of the service
+	Port int `json:"port"`
+}
+
+type ExecProbe struct {
+	Command []string `json:"command"`
+}
+
+func (p Probe) Validate() error {
+	checks := 0
+	if p.HTTP != nil {
+		checks++
+	}
+	if p.TCP != nil {
+		checks++
+	}
+	if p.Exec != nil {
+		checks++
+		if len(p.Exec.Command) == 0 {
+			return errors.New("exec probe needs a command")
+		}
+	}
+	if checks != 1 {
+		return errors.New("probe needs exactly one of http, tcp or exec")
+	}
+	if p.InitialDelaySeconds < 0 || p.IntervalSeconds < 0 || p.TimeoutSeconds < 0 ||
+		p.SuccessThreshold < 0 || p.FailureThreshold < 0 {
+		return errors.New("probe settings cannot be negative")
+	}
+
+	return nil
+}
+
+func (p Probe) InitialDelay() time.Duration {
+	return time.Duration(p.InitialDelaySeconds) * time.Second
+}
+
+func (p Probe) Interval() time.Duration {
+	return durationOr(p.IntervalSeconds, 10)
+}
+
+func (p Probe) Timeout() time.Duration {
+	return durationOr(p.TimeoutSeconds, 1)
+}
+
+func (p Probe) Successes() int {
+	return intOr(p.SuccessThreshold, 1)
+}
+
+func (p Probe) Failures() int {
+	return intOr(p.FailureThreshold, 3)
+}
+
+func durationOr(seconds int, def int) time.Duration {
+	return time.Duration(intOr(seconds, def)) * time.Second
+}
+
+func intOr(value int, def int) int {
+	if value == 0 {
+		return def
+	}
+
+	return value
+}
@@ -0,0 +1,34 @@
+package service
+
+// Resources is an amount of CPU, in cores, and memory, in megabytes.
+type Resources struct {
+	CPU    float64 `json:"cpu"`
+	Memory int64   `json:"memory"`
+}
+
+func (r Resources) Add(other Resources) Resources {
+	return Resources{
+		CPU:    r.CPU + other.CPU,
+		Memory: r.Memory + other.Memory,
+	}
+}
+
+// Fits reports whether the resources fit in the capacity. A zero capacity is
+// unknown and fits anything.
+func (r Resources) Fits(capacity Resources) bool {
+	if capacity.CPU > 0 && r.CPU > capacity.CPU {
+		return false
+	}
+	if capacity.Memory > 0 && r.Memory > capacity.Memory {
+		return false
+	}
+
+	return true
+}
+
+// ResourceRequirements are the resources a service is scheduled with, and
+// the limits it is run with. Zero values are unset.
+type ResourceRequirements struct {
+	Requests Resources `json:"requests"`
+	Limits   Resources `json:"limits"`
+}
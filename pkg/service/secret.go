@@ -0,0 +1,34 @@
+package service
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// SecretRef injects a secret from the controller's store into a service,
+// either as an environment variable or as a file.
+type SecretRef struct {
+	Name string `json:"name"`
+	// Env is the environment variable the secret is set in
+	Env string `json:"env"`
+	// File is the absolute path of the file the secret is written to
+	File string `json:"file"`
+}
+
+func (s SecretRef) Validate() error {
+	if s.Name == "" {
+		return errors.New("secret name is required")
+	}
+	if (s.Env == "") == (s.File == "") {
+		return errors.New("secret needs exactly one of env or file")
+	}
+	if strings.Contains(s.Env, "=") {
+		return errors.New("invalid environment variable name")
+	}
+	if s.File != "" && (!path.IsAbs(s.File) || strings.HasSuffix(s.File, "/")) {
+		return errors.New("secret file must be an absolute file path")
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package state
+
+import "metis/pkg/status"
+
+// ContainerStatus is what an agent knows about a container it runs.
+type ContainerStatus struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Project, Revision, Instance, Controller and Spec are read from the
+	// labels of the container. Spec is the hash of the service's
+	// configuration.
+	Project    string `json:"project"`
+	Revision   int    `json:"revision"`
+	Instance   string `json:"instance"`
+	Controller string `json:"controller"`
+	Spec       string `json:"spec"`
+	Image      string `json:"image"`
+	// Ports holds the node port each container port is published on, keyed
+	// by port and protocol, such as 80/tcp.
+	Ports map[string]int32 `json:"ports"`
+
+	// State is the docker state of the container, such as running or exited
+	State    string `json:"state"`
+	ExitCode int    `json:"exit_code"`
+
+	// Probed is whether the agent is running the service's probes. If it is
+	// not, Live and Ready are not known.
+	Probed bool `json:"probed"`
+	Live   bool `json:"live"`
+	Ready  bool `json:"ready"`
+}
+
+func (c ContainerStatus) Running() bool {
+	return c.State == "running"
+}
+
+func (c ContainerStatus) Exited() bool {
+	return c.State == "exited" || c.State == "dead"
+}
+
+// Apply updates the status of a service from the status of its container.
+func (c ContainerStatus) Apply(srv ServiceState) ServiceState {
+	if c.Exited() {
+		srv.Status = status.EXITED
+		srv.ExitCode = c.ExitCode
+		srv.Ready = false
+		return srv
+	}
+
+	if c.Running() {
+		srv.Status = status.RUNNING
+	} else if srv.Status == status.RUNNING {
+		srv.Status = status.UNHEALTHY
+	}
+
+	if !c.Running() {
+		srv.Ready = false
+		return srv
+	}
+
+	if !c.Live {
+		srv.Status = status.UNHEALTHY
+	}
+	srv.Ready = c.Ready
+
+	return srv
+}
@@ -3,6 +3,7 @@ package state
 import (
 	"metis/pkg/service"
 	"metis/pkg/status"
+	"time"
 )
 
 type ServiceState struct {
@@ -12,4 +13,32 @@ type ServiceState struct {
 	ID          string
 	ExposedPort int32
 	Node        string
+
+	// ExposedPorts holds the node port each port of the service is published
+	// on, keyed by port name. ExposedPort is the node port of the first.
+	ExposedPorts map[string]int32
+
+	// RunningSince is when the controller first saw the service running
+	RunningSince time.Time
+	// Ready is whether the service is passing its readiness probe, and so
+	// can be routed to
+	Ready bool
+	// ExitCode is the exit code of the container once it has exited
+	ExitCode int
+	// Draining is whether the service is being removed. It is taken out of
+	// routing first, and destroyed on a later update.
+	Draining bool
+}
+
+// PortFor returns the node port the named port of the service is published
+// on.
+func (s ServiceState) PortFor(name string) (int32, bool) {
+	if port, ok := s.ExposedPorts[name]; ok {
+		return port, true
+	}
+	if name == "" && s.ExposedPort != 0 {
+		return s.ExposedPort, true
+	}
+
+	return 0, false
 }
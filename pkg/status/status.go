@@ -7,4 +7,14 @@ const (
 	RUNNING   = "RUNNING"
 	STOPPED   = "STOPPED"
 	UNHEALTHY = "UNHEALTHY"
+	LOST      = "LOST"
+	EXITED    = "EXITED"
+
+	// Services that ended and are not restarted under the project's restart
+	// policy are kept as completed or failed
+	COMPLETED = "COMPLETED"
+	FAILED    = "FAILED"
+
+	// CRASHLOOP is the status of projects whose services keep failing
+	CRASHLOOP = "CRASHLOOP"
 )
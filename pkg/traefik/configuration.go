@@ -2,11 +2,19 @@ package traefik
 
 type Configuration struct {
 	HTTP HttpConfig
+	TCP  TCPConfig
+	UDP  UDPConfig
+	TLS  TLSConfig
 }
 
 type Router struct {
-	Rule    string `json:"rule"`
-	Service string `json:"service"`
+	Rule        string   `json:"rule"`
+	Service     string   `json:"service"`
+	EntryPoints []string `json:"entryPoints"`
+	// Priority defaults to the length of the rule if zero
+	Priority    int        `json:"priority"`
+	Middlewares []string   `json:"middlewares"`
+	TLS         *RouterTLS `json:"tls"`
 }
 
 type HttpConfigs struct {
@@ -14,20 +22,77 @@ type HttpConfigs struct {
 }
 
 type HttpConfig struct {
-	Routers  map[string]Router
+	Routers     map[string]Router
+	Services    map[string]Service
+	Middlewares map[string]Middleware
+}
+
+type TCPConfig struct {
+	Routers  map[string]TCPRouter
+	Services map[string]Service
+}
+
+// TCPRouter routes TCP connections by TLS server name. Unless the rule matches
+// every server name, the TLS connection is passed through to the service.
+type TCPRouter struct {
+	Rule           string   `json:"rule"`
+	Service        string   `json:"service"`
+	EntryPoints    []string `json:"entryPoints"`
+	TLSPassthrough bool     `json:"passthrough"`
+}
+
+type UDPConfig struct {
+	Routers  map[string]UDPRouter
 	Services map[string]Service
 }
 
+// UDPRouter sends all traffic from its entrypoints to a service.
+type UDPRouter struct {
+	Service     string   `json:"service"`
+	EntryPoints []string `json:"entryPoints"`
+}
+
+// Service is either a load balancer over servers, or if Weighted is set, a
+// weighted round robin over other services.
 type Service struct {
 	LoadBalancer LoadBalancer
+	Weighted     *Weighted
+}
+
+type Weighted struct {
+	Services []WeightedService
+	Sticky   *Sticky
+}
+
+type WeightedService struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
 }
 
 type LoadBalancer struct {
-	Servers []URL
+	Servers        []Server
+	HealthCheck    *HealthCheck
+	Sticky         *Sticky
+	PassHostHeader *bool
 }
 
-type URL struct {
-	URL string `json:"url"`
+type HealthCheck struct {
+	Path     string `json:"path"`
+	Interval string `json:"interval"`
+	Timeout  string `json:"timeout"`
+}
+
+// Sticky keeps clients on the same server with a cookie.
+type Sticky struct {
+	CookieName string `json:"name"`
+	Secure     bool   `json:"secure"`
+	HTTPOnly   bool   `json:"httpOnly"`
+}
+
+// Server is an HTTP server by URL, or a TCP or UDP server by address.
+type Server struct {
+	URL     string `json:"url"`
+	Address string `json:"address"`
 }
 
 func (c Configuration) ToMap() map[string]interface{} {
@@ -39,28 +104,155 @@ func (c Configuration) ToMap() map[string]interface{} {
 		rtr := map[string]interface{}{}
 		rtr["rule"] = router.Rule
 		rtr["service"] = router.Service
+		if len(router.EntryPoints) > 0 {
+			rtr["entryPoints"] = router.EntryPoints
+		}
+		if router.Priority > 0 {
+			rtr["priority"] = router.Priority
+		}
+		if len(router.Middlewares) > 0 {
+			rtr["middlewares"] = router.Middlewares
+		}
+		if router.TLS != nil {
+			tls := map[string]interface{}{}
+			if router.TLS.CertResolver != "" {
+				tls["certResolver"] = router.TLS.CertResolver
+			}
+			if router.TLS.Options != "" {
+				tls["options"] = router.TLS.Options
+			}
+			rtr["tls"] = tls
+		}
 		routers[key] = rtr
 	}
 
 	config["http"].(map[string]interface{})["routers"] = routers
+	config["http"].(map[string]interface{})["services"] = servicesToMap(c.HTTP.Services)
+
+	if len(c.HTTP.Middlewares) > 0 {
+		middlewares := map[string]interface{}{}
+		for key, middleware := range c.HTTP.Middlewares {
+			middlewares[key] = middleware.toMap()
+		}
+		config["http"].(map[string]interface{})["middlewares"] = middlewares
+	}
+
+	if len(c.TCP.Routers) > 0 {
+		tcpRouters := map[string]interface{}{}
+		for key, router := range c.TCP.Routers {
+			rtr := map[string]interface{}{}
+			rtr["rule"] = router.Rule
+			rtr["service"] = router.Service
+			rtr["entryPoints"] = router.EntryPoints
+			if router.TLSPassthrough {
+				rtr["tls"] = map[string]interface{}{"passthrough": true}
+			}
+			tcpRouters[key] = rtr
+		}
+
+		config["tcp"] = map[string]interface{}{
+			"routers":  tcpRouters,
+			"services": servicesToMap(c.TCP.Services),
+		}
+	}
+
+	if len(c.UDP.Routers) > 0 {
+		udpRouters := map[string]interface{}{}
+		for key, router := range c.UDP.Routers {
+			udpRouters[key] = map[string]interface{}{
+				"service":     router.Service,
+				"entryPoints": router.EntryPoints,
+			}
+		}
+
+		config["udp"] = map[string]interface{}{
+			"routers":  udpRouters,
+			"services": servicesToMap(c.UDP.Services),
+		}
+	}
+
+	if len(c.TLS.Certificates) > 0 {
+		certificates := []map[string]string{}
+		for _, certificate := range c.TLS.Certificates {
+			certificates = append(certificates, map[string]string{
+				"certFile": certificate.CertFile,
+				"keyFile":  certificate.KeyFile,
+			})
+		}
+		config["tls"] = map[string]interface{}{"certificates": certificates}
+	}
+
+	return config
+}
 
-	services := map[string]interface{}{}
+// servicesToMap renders services of any protocol. HTTP servers are rendered
+// by URL and TCP and UDP servers by address.
+func servicesToMap(services map[string]Service) map[string]interface{} {
+	rendered := map[string]interface{}{}
 
-	for key, service := range c.HTTP.Services {
+	for key, service := range services {
 		srv := map[string]interface{}{}
+		if service.Weighted != nil {
+			weighted := []map[string]interface{}{}
+			for _, weightedService := range service.Weighted.Services {
+				weighted = append(weighted, map[string]interface{}{
+					"name":   weightedService.Name,
+					"weight": weightedService.Weight,
+				})
+			}
+
+			srv["weighted"] = map[string]interface{}{"services": weighted}
+			if service.Weighted.Sticky != nil {
+				srv["weighted"].(map[string]interface{})["sticky"] = service.Weighted.Sticky.toMap()
+			}
+			rendered[key] = srv
+			continue
+		}
+
 		loadBalancer := map[string]interface{}{}
 		loadBalancer["servers"] = []map[string]string{}
-		for _, url := range service.LoadBalancer.Servers {
-			loadBalancer["servers"] = append(loadBalancer["servers"].([]map[string]string), map[string]string{
-				"url": url.URL,
-			})
+		for _, server := range service.LoadBalancer.Servers {
+			entry := map[string]string{}
+			if server.URL != "" {
+				entry["url"] = server.URL
+			} else {
+				entry["address"] = server.Address
+			}
+			loadBalancer["servers"] = append(loadBalancer["servers"].([]map[string]string), entry)
+		}
+
+		if healthCheck := service.LoadBalancer.HealthCheck; healthCheck != nil {
+			check := map[string]interface{}{"path": healthCheck.Path}
+			if healthCheck.Interval != "" {
+				check["interval"] = healthCheck.Interval
+			}
+			if healthCheck.Timeout != "" {
+				check["timeout"] = healthCheck.Timeout
+			}
+			loadBalancer["healthCheck"] = check
+		}
+		if service.LoadBalancer.Sticky != nil {
+			loadBalancer["sticky"] = service.LoadBalancer.Sticky.toMap()
+		}
+		if service.LoadBalancer.PassHostHeader != nil {
+			loadBalancer["passHostHeader"] = *service.LoadBalancer.PassHostHeader
 		}
 
 		srv["loadBalancer"] = loadBalancer
-		services[key] = srv
+		rendered[key] = srv
 	}
 
-	config["http"].(map[string]interface{})["services"] = services
+	return rendered
+}
 
-	return config
+func (s Sticky) toMap() map[string]interface{} {
+	cookie := map[string]interface{}{
+		"secure":   s.Secure,
+		"httpOnly": s.HTTPOnly,
+	}
+	if s.CookieName != "" {
+		cookie["name"] = s.CookieName
+	}
+
+	return map[string]interface{}{"cookie": cookie}
 }
@@ -0,0 +1,80 @@
+package traefik
+
+// Middleware is one of Traefik's HTTP middlewares, with exactly one of the
+// fields set.
+type Middleware struct {
+	RedirectScheme *RedirectScheme
+	BasicAuth      *BasicAuth
+	RateLimit      *RateLimit
+	Compress       bool
+	Headers        *Headers
+}
+
+type RedirectScheme struct {
+	Scheme    string `json:"scheme"`
+	Permanent bool   `json:"permanent"`
+}
+
+type BasicAuth struct {
+	Users []string `json:"users"`
+	Realm string   `json:"realm"`
+}
+
+type RateLimit struct {
+	Average int    `json:"average"`
+	Burst   int    `json:"burst"`
+	Period  string `json:"period"`
+}
+
+type Headers struct {
+	CustomRequestHeaders  map[string]string `json:"customRequestHeaders"`
+	CustomResponseHeaders map[string]string `json:"customResponseHeaders"`
+}
+
+// RouterTLS serves a router over HTTPS.
+type RouterTLS struct {
+	CertResolver string `json:"certResolver"`
+	Options      string `json:"options"`
+}
+
+type TLSConfig struct {
+	Certificates []Certificate
+}
+
+// Certificate is a certificate and key on the Traefik host, served for the
+// domains it is valid for.
+type Certificate struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+func (m Middleware) toMap() map[string]interface{} {
+	switch {
+	case m.RedirectScheme != nil:
+		return map[string]interface{}{"redirectScheme": map[string]interface{}{
+			"scheme":    m.RedirectScheme.Scheme,
+			"permanent": m.RedirectScheme.Permanent,
+		}}
+	case m.BasicAuth != nil:
+		basicAuth := map[string]interface{}{"users": m.BasicAuth.Users}
+		if m.BasicAuth.Realm != "" {
+			basicAuth["realm"] = m.BasicAuth.Realm
+		}
+		return map[string]interface{}{"basicAuth": basicAuth}
+	case m.RateLimit != nil:
+		return map[string]interface{}{"rateLimit": map[string]interface{}{
+			"average": m.RateLimit.Average,
+			"burst":   m.RateLimit.Burst,
+			"period":  m.RateLimit.Period,
+		}}
+	case m.Compress:
+		return map[string]interface{}{"compress": map[string]interface{}{}}
+	case m.Headers != nil:
+		return map[string]interface{}{"headers": map[string]interface{}{
+			"customRequestHeaders":  m.Headers.CustomRequestHeaders,
+			"customResponseHeaders": m.Headers.CustomResponseHeaders,
+		}}
+	}
+
+	return map[string]interface{}{}
+}
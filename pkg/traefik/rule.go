@@ -0,0 +1,48 @@
+package traefik
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HTTPRule builds a rule matching requests to one of the hosts, one of the
+// path prefixes, every header and one of the methods. Empty matchers match
+// all requests, though a rule without any matchers matches none.
+func HTTPRule(hosts []string, pathPrefixes []string, headers map[string]string, methods []string) string {
+	matchers := []string{}
+	if len(hosts) > 0 {
+		matchers = append(matchers, matcher("Host", hosts...))
+	}
+	if len(pathPrefixes) > 0 {
+		matchers = append(matchers, matcher("PathPrefix", pathPrefixes...))
+	}
+
+	keys := []string{}
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		matchers = append(matchers, matcher("Headers", key, headers[key]))
+	}
+
+	if len(methods) > 0 {
+		matchers = append(matchers, matcher("Method", methods...))
+	}
+
+	if len(matchers) == 0 {
+		return "Host(``)"
+	}
+
+	return strings.Join(matchers, " && ")
+}
+
+func matcher(name string, args ...string) string {
+	quoted := []string{}
+	for _, arg := range args {
+		quoted = append(quoted, fmt.Sprintf("`%s`", arg))
+	}
+
+	return fmt.Sprintf("%s(%s)", name, strings.Join(quoted, ", "))
+}
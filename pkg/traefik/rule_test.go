@@ -0,0 +1,56 @@
+package traefik
+
+import "testing"
+
+func TestHTTPRule(t *testing.T) {
+	tests := []struct {
+		name         string
+		hosts        []string
+		pathPrefixes []string
+		headers      map[string]string
+		methods      []string
+		want         string
+	}{
+		{
+			name: "no matchers",
+			want: "Host(``)",
+		},
+		{
+			name:  "single host",
+			hosts: []string{"example.com"},
+			want:  "Host(`example.com`)",
+		},
+		{
+			name:  "several hosts",
+			hosts: []string{"example.com", "www.example.com"},
+			want:  "Host(`example.com`, `www.example.com`)",
+		},
+		{
+			name:         "path prefixes only",
+			pathPrefixes: []string{"/api", "/v2"},
+			want:         "PathPrefix(`/api`, `/v2`)",
+		},
+		{
+			name:    "headers are sorted",
+			headers: map[string]string{"X-Version": "2", "X-Canary": "true"},
+			want:    "Headers(`X-Canary`, `true`) && Headers(`X-Version`, `2`)",
+		},
+		{
+			name:         "every matcher",
+			hosts:        []string{"example.com"},
+			pathPrefixes: []string{"/api"},
+			headers:      map[string]string{"X-Canary": "true"},
+			methods:      []string{"GET", "POST"},
+			want:         "Host(`example.com`) && PathPrefix(`/api`) && Headers(`X-Canary`, `true`) && Method(`GET`, `POST`)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := HTTPRule(test.hosts, test.pathPrefixes, test.headers, test.methods)
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}